@@ -0,0 +1,100 @@
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"matrix/pkg/utils"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// The exit code of a diff that found changes, errors exit with 1 like every other command.
+const DIFF_CHANGED_EXIT_CODE = 3
+
+// diffCmd represents the diff command
+var diffCmd = &cobra.Command{
+	Use:   "diff [OLD] [NEW]",
+	Short: "Compare two saved scans and report what changed.",
+	Long: `This command compares two scans saved with --save and reports the new and disappeared hosts and the newly opened and closed ports.
+	The scans are given by their ID or the path of their file.
+	Without NEW the scan is compared with the latest saved scan, without either the latest two scans of the same network are compared.
+	The command exits with 3 when something changed, so a nightly job can raise an alert.
+	`,
+	Args: cobra.MaximumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		directory, err := storeDirectory()
+		if err != nil {
+			return err
+		}
+		var earlier, later utils.SavedScan
+		switch len(args) {
+		case 0:
+			earlier, later, err = utils.LatestScanPair(directory)
+		case 1:
+			earlier, later, err = loadAgainstLatest(directory, args[0])
+		default:
+			if earlier, err = utils.LoadScan(directory, args[0]); err == nil {
+				later, err = utils.LoadScan(directory, args[1])
+			}
+		}
+		if err != nil {
+			return err
+		}
+		changes, err := utils.DiffScans(earlier, later)
+		if err != nil {
+			return err
+		}
+
+		output, err := openOutput()
+		if err != nil {
+			return err
+		}
+		defer closeOutput(output, &err)
+		fmt.Fprintf(os.Stderr, "Comparing %s with %s.\n", earlier.ID, later.ID)
+		if err := utils.WriteScanChanges(output, outputFormat, changes); err != nil {
+			return err
+		}
+		if len(changes) > 0 {
+			// Changes are no mistake of the user, cobra has nothing to add to them.
+			cmd.SilenceErrors, cmd.SilenceUsage = true, true
+			return exitCode(DIFF_CHANGED_EXIT_CODE)
+		}
+		return nil
+	},
+}
+
+// This function loads a saved scan along with the latest scan in the store.
+func loadAgainstLatest(directory string, reference string) (utils.SavedScan, utils.SavedScan, error) {
+	earlier, err := utils.LoadScan(directory, reference)
+	if err != nil {
+		return earlier, utils.SavedScan{}, err
+	}
+	ids, err := utils.ListScans(directory)
+	if err != nil {
+		return earlier, utils.SavedScan{}, err
+	}
+	if len(ids) == 0 || ids[len(ids)-1] == earlier.ID {
+		return earlier, utils.SavedScan{}, fmt.Errorf("%s is the latest saved scan, there is nothing newer to compare it with", earlier.ID)
+	}
+	later, err := utils.LoadScan(directory, ids[len(ids)-1])
+	return earlier, later, err
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+}
@@ -16,17 +16,29 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"matrix/pkg/scan"
 	"matrix/pkg/utils"
 	"os"
-	"text/tabwriter"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 )
 
 var (
-	networkCidr string
-	pingTimer   int
+	networkCidr   string
+	pingTimer     int
+	pingRetries   int
+	interfaceName string
+	pingMethods   []string
+	tcpPingPorts  string
+	udpPingPorts  string
+	pingCount     int
+	showDown      bool
 )
 
 // hostScanCmd represents the hostScan command
@@ -35,25 +47,106 @@ var hostScanCmd = &cobra.Command{
 	Short: "Discover active hosts in your network.",
 	Long: `The hostScan allows you to scan all hosts inside a network and check if they are online or not.
 	It is capable of mapping IPs to their hostnames, making it easier to find a rogue raspberry pi ;)
+	IPv6 networks up to a /112 are pinged address by address with ICMPv6.
+	Larger IPv6 networks, such as the /64 of your LAN or fe80::/64, are found by pinging the all-nodes group of the local link,
+	every host answers from its link-local address.
+	Devices which drop pings can still be found with other methods (--method icmp,tcp,syn,udp,arp), a host is up as soon as one of them gets an answer.
+	The tcp method connects to a few common ports and needs no superuser access, a refused connection counts as an answer too.
+	The arp method only works on your own segment and also shows the MAC address and vendor of every host.
+	With --count every host is pinged several times, one round every second, to report its packet loss and round trip times.
+	With --interval the scan is repeated to keep an eye on the network, only the hosts that come and go are printed.
 	`,
-	Run: func(cmd *cobra.Command, args []string) {
-		scanResults := utils.DiscoverHosts(networkCidr, pingTimer)
-		writer := tabwriter.NewWriter(os.Stdout, 1, 8, 0, ' ', tabwriter.AlignRight|tabwriter.Debug)
-		fmt.Fprintln(writer, "\nScan Complete")
-		fmt.Fprintln(writer, "--------------------------------------------")
-		fmt.Fprintln(writer, "IP Address\tState\tHostname\tResponse Time")
-		fmt.Fprintln(writer, "--------------------------------------------")
-		for _, result := range scanResults {
-			if result.State == "Up" {
-				fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", result.Ipaddress, result.State, result.Hostname, result.ResponseTime)
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		if err := utils.ValidateDiscoveryMethods(pingMethods); err != nil {
+			return err
+		}
+		if err := validateWatch(); err != nil {
+			return err
+		}
+		output, err := openOutput()
+		if err != nil {
+			return err
+		}
+		defer closeOutput(output, &err)
+
+		// One round of the scan, the hosts are streamed as they answer when asked to.
+		var streamErr error
+		runScan := func(ctx context.Context, stream bool) (utils.SavedScan, error) {
+			// Display welcome message and progress.
+			// The scan ends once every host has answered or run out of time, so we can only tell how long it takes at most.
+			progress := newProgress("replies", "up")
+			startTime := time.Now()
+			scanResults, err := scan.Hosts(ctx, scan.HostOptions{
+				Network:    networkCidr,
+				PingTime:   time.Duration(pingTimer) * time.Second,
+				Retries:    pingRetries,
+				Interface:  interfaceName,
+				Methods:    pingMethods,
+				TCPPorts:   tcpPingPorts,
+				UDPPorts:   udpPingPorts,
+				Count:      pingCount,
+				ReportDown: showDown,
+				Names:      nameOptions(),
+				OnStart: func(addresses int, longest time.Duration) {
+					fmt.Fprintf(os.Stderr, "This scan will take at most %s to find LAN peers.\n", longest.Round(time.Second))
+					progress.Start(addresses, time.Now().Add(longest))
+				},
+				OnHost: func(host scan.Host) {
+					progress.Step(host.State == "Up")
+					if stream && streamErr == nil {
+						streamErr = utils.StreamHostResult(output, host)
+					}
+				},
+			})
+			progress.Stop()
+			return utils.SavedScan{Kind: "hostScan", Target: networkCidr, Started: startTime, Finished: time.Now(), Hosts: scanResults}, err
+		}
+		if watchInterval > 0 {
+			return watchScans(cmd.Context(), output, runScan)
+		}
+
+		saved, err := runScan(cmd.Context(), streamOutput)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			return err
+		}
+		if saveErr := saveScan(saved, err != nil); saveErr != nil {
+			return saveErr
+		}
+		if streamOutput {
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Scan interrupted.")
 			}
+			return streamErr
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Scan interrupted, showing the hosts found so far.")
 		}
-		writer.Flush()
+
+		return utils.WriteHostResults(output, outputFormat, saved.Hosts, saved.Started)
 	},
 }
 
+// This function writes a list of ports the way the user would type it.
+func portList(ports []int) string {
+	var list []string
+	for _, port := range ports {
+		list = append(list, strconv.Itoa(port))
+	}
+	return strings.Join(list, ",")
+}
+
 func init() {
 	rootCmd.AddCommand(hostScanCmd)
-	hostScanCmd.Flags().IntVarP(&pingTimer, "pingtime", "t", 10, "Number of seconds to wait for a ping reply. Default is 10 seconds.")
-	hostScanCmd.Flags().StringVarP(&networkCidr, "cidr", "c", "192.168.0.0/24", "The CIDR notation of the network you want to scan.")
+	hostScanCmd.Flags().IntVarP(&pingTimer, "pingtime", "t", 10, "Number of seconds to wait for every host to answer. Default is 10 seconds. The scan ends as soon as every host has answered or run out of time.")
+	hostScanCmd.Flags().IntVarP(&pingRetries, "retries", "r", 1, "How many more times the icmp and arp methods ask a host that did not answer.")
+	hostScanCmd.Flags().StringVarP(&networkCidr, "cidr", "c", "192.168.0.0/24", "The CIDR notation of the network you want to scan, IPv4 or IPv6.")
+	hostScanCmd.Flags().StringVarP(&interfaceName, "interface", "i", "", "The interface to scan from with the arp method or for large IPv6 networks. Needed for link-local networks.")
+	hostScanCmd.Flags().StringSliceVarP(&pingMethods, "method", "m", []string{"icmp"}, "The ways to look for hosts: "+strings.Join(utils.DiscoveryMethods, ", ")+". tcp needs no superuser access, neither does icmp where ping sockets are allowed.")
+	hostScanCmd.Flags().StringVar(&tcpPingPorts, "tcp-ports", "", "The ports knocked on by the tcp and syn methods, written like the --ports of portScan. Default is "+portList(utils.DEFAULT_TCP_PING_PORTS)+".")
+	hostScanCmd.Flags().IntVarP(&pingCount, "count", "n", 1, "How many times every host is pinged, more than once reports the packet loss and the min/avg/max/stddev round trip time.")
+	hostScanCmd.Flags().BoolVar(&showDown, "show-down", false, "Also list the hosts that did not answer.")
+	hostScanCmd.Flags().StringVar(&udpPingPorts, "udp-ports", "", "The ports probed by the udp method. Default is "+portList(utils.DEFAULT_UDP_PING_PORTS)+".")
+	addNameFlags(hostScanCmd)
+	addSaveFlag(hostScanCmd)
+	addWatchFlags(hostScanCmd)
 }
@@ -17,6 +17,7 @@ package cmd
 
 import (
 	"matrix/pkg/utils"
+	"time"
 
 	"github.com/spf13/cobra"
 )
@@ -26,6 +27,9 @@ var (
 	serverHost          string
 	websocketClientMode bool
 	websocketPath       string
+	udpClientMode       bool
+	udpWait             time.Duration
+	clientLink          string
 )
 
 // launchClientCmd represents the launchTestClient command
@@ -34,13 +38,29 @@ var launchClientCmd = &cobra.Command{
 	Short: "Launch a interactive client to test server responses.",
 	Long: `This command launches a client for a websocket, TCP or a gRPC server.
 	It opens a interactive prompt and allows users to send customized messages to the server and test its output.
+	With --tls the client talks TLS (wss:// in websocket mode) and prints the version and cipher it negotiated,
+	--ca trusts a private CA, --insecure accepts any certificate and --cert with --key answer servers asking for one.
+	With --udp every line is sent as a datagram and the replies are shown with their round trip time. A multicast group
+	as the server address is joined, and all the replies arriving within --wait are shown.
 	`,
-	Run: func(cmd *cobra.Command, args []string) {
-		if !websocketClientMode {
-			utils.TcpClient(serverPort, serverHost)
-		} else if websocketClientMode {
-			utils.WebsocketClient(serverPort, serverHost, websocketPath)
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := validateUDP(udpClientMode, websocketClientMode, ""); err != nil {
+			return err
 		}
+		config := utils.ClientConfig{Host: serverHost, Port: serverPort, Path: websocketPath, Wait: udpWait, Interface: clientLink}
+		if tlsRequested() {
+			var err error
+			if config.TLS, err = utils.ClientTLSConfig(tlsOptions, serverHost); err != nil {
+				return err
+			}
+		}
+		if udpClientMode {
+			return utils.UdpClient(config)
+		}
+		if websocketClientMode {
+			return utils.WebsocketClient(config)
+		}
+		return utils.TcpClient(config)
 	},
 }
 
@@ -50,4 +70,8 @@ func init() {
 	launchClientCmd.Flags().StringVarP(&serverHost, "server", "s", "localhost", "The address where your server is active.")
 	launchClientCmd.Flags().BoolVarP(&websocketClientMode, "wsmode", "w", false, "Start the client in web socket mode.")
 	launchClientCmd.Flags().StringVarP(&websocketPath, "wspath", "f", "/", "The path on the server where the socket is located.")
+	launchClientCmd.Flags().BoolVar(&udpClientMode, "udp", false, "Start the client in UDP mode, sending every line as a datagram.")
+	launchClientCmd.Flags().DurationVar(&udpWait, "wait", utils.DEFAULT_UDP_WAIT, "How long to wait for the replies to a datagram.")
+	launchClientCmd.Flags().StringVar(&clientLink, "interface", "", "The interface a multicast group is joined on. Default is the one the system picks.")
+	addTLSFlags(launchClientCmd, false)
 }
@@ -17,14 +17,24 @@ package cmd
 
 import (
 	"matrix/pkg/utils"
+	"strings"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 var (
 	portNumber    int
 	replyMessage  string
 	websocketMode bool
+	framing       string
+	frameSize     int
+	rulesFile     string
+	faultsFile    string
+	faults        utils.FaultConfig
+	udpServerMode bool
+	serverGroup   string
+	serverLink    string
 )
 
 // launchServerCmd represents the serve command
@@ -32,13 +42,49 @@ var launchServerCmd = &cobra.Command{
 	Use:   "launchServer",
 	Short: "Start a server for testing clients.",
 	Long: `This command starts a testing server which replies back with Echo of what it receives.
-	In case you want to send a specific reply, you can tell the server to send back that reply for each client message.`,
-	Run: func(cmd *cobra.Command, args []string) {
-		if !websocketMode {
-			utils.ServeTCP(portNumber, replyMessage)
-		} else if websocketMode {
-			utils.ServeWebsocket(portNumber, replyMessage)
+	In case you want to send a specific reply, you can tell the server to send back that reply for each client message.
+	The TCP server reads newline terminated lines by default, binary protocols can be framed with --framing:
+	length2 and length4 read a big-endian length prefix before every message, fixed reads messages of --frame-size bytes
+	and raw takes whatever arrives. Replies are framed the same way and binary messages are echoed back untouched.
+	A YAML or JSON --rules file answers the messages matching its rules (exact text, a regular expression or a hex prefix)
+	with templated replies, after a delay or by closing the connection, the other messages get the usual reply.
+	To test how a client copes with a bad network the server can inject faults, each with its own probability:
+	latency, partial writes, corrupted bytes, resets, half-closes and trickled replies, and it can refuse every connection
+	after the first --refuse-after ones. The faults are set with flags or a --faults file, the flags win.
+	With --tls the server talks TLS with the --cert and --key given, or a self-signed certificate it generates,
+	and --ca makes it require client certificates signed by those CAs.
+	With --udp the server answers every datagram on its own and logs the peer it came from,
+	--group joins a multicast group to test discovery protocols. Of the faults only latency, corruption and partial
+	replies apply to datagrams.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := validateUDP(udpServerMode, websocketMode, serverGroup); err != nil {
+			return err
 		}
+		config := utils.ServerConfig{Port: portNumber, Reply: replyMessage, Framing: framing, FrameSize: frameSize, Group: serverGroup, Interface: serverLink}
+		if rulesFile != "" {
+			rules, err := utils.LoadResponseRules(rulesFile)
+			if err != nil {
+				return err
+			}
+			config.Rules = rules
+		}
+		faultConfig, err := faultOptions(cmd)
+		if err != nil {
+			return err
+		}
+		config.Faults = faultConfig
+		if tlsRequested() {
+			if config.TLS, err = utils.ServerTLSConfig(tlsOptions); err != nil {
+				return err
+			}
+		}
+		if udpServerMode {
+			return utils.ServeUDP(config)
+		}
+		if websocketMode {
+			return utils.ServeWebsocket(config)
+		}
+		return utils.ServeTCP(config)
 	},
 }
 
@@ -47,4 +93,65 @@ func init() {
 	launchServerCmd.Flags().IntVarP(&portNumber, "port", "p", 5000, "The port on which to host the server.")
 	launchServerCmd.Flags().StringVarP(&replyMessage, "reply", "r", "ECHO", "The reply to send when the server accepts a client message.\nECHO server is default and sends back what client sent.")
 	launchServerCmd.Flags().BoolVarP(&websocketMode, "wsmode", "w", false, "Start the server in web socket mode.")
+	launchServerCmd.Flags().StringVar(&framing, "framing", "newline", "How the TCP server tells the messages apart: "+strings.Join(utils.Framings, ", ")+".")
+	launchServerCmd.Flags().IntVar(&frameSize, "frame-size", 0, "The size in bytes of every message with --framing fixed.")
+	launchServerCmd.Flags().BoolVar(&udpServerMode, "udp", false, "Start the server in UDP mode, answering every datagram.")
+	launchServerCmd.Flags().StringVar(&serverGroup, "group", "", "The multicast group the UDP server joins, such as 239.1.2.3 or ff02::1:3.")
+	launchServerCmd.Flags().StringVar(&serverLink, "interface", "", "The interface the multicast group is joined on. Default is the one the system picks.")
+	addTLSFlags(launchServerCmd, true)
+	launchServerCmd.Flags().StringVar(&rulesFile, "rules", "", "A YAML or JSON file of rules answering the messages they match.")
+
+	launchServerCmd.Flags().StringVar(&faultsFile, "faults", "", "A YAML or JSON file of faults to inject, the fault flags override it.")
+	launchServerCmd.Flags().DurationVar(&faults.Latency, "latency", 0, "Delay the replies by this long.")
+	launchServerCmd.Flags().DurationVar(&faults.Jitter, "jitter", 0, "Delay the replies by up to this long more, at random.")
+	launchServerCmd.Flags().Float64Var(&faults.LatencyChance, "latency-chance", 1, "The probability that a reply is delayed.")
+	launchServerCmd.Flags().Float64Var(&faults.PartialChance, "partial-chance", 0, "The probability that only the first bytes of a reply are sent.")
+	launchServerCmd.Flags().Float64Var(&faults.CorruptChance, "corrupt-chance", 0, "The probability that a byte of a reply is corrupted.")
+	launchServerCmd.Flags().Float64Var(&faults.ResetChance, "reset-chance", 0, "The probability that the connection is reset instead of replying.")
+	launchServerCmd.Flags().Float64Var(&faults.HalfCloseChance, "half-close-chance", 0, "The probability that the server stops sending after a reply.")
+	launchServerCmd.Flags().Float64Var(&faults.TrickleChance, "trickle-chance", 0, "The probability that a reply is sent one byte at a time.")
+	launchServerCmd.Flags().DurationVar(&faults.TrickleDelay, "trickle-delay", utils.DEFAULT_TRICKLE_DELAY, "The pause between the bytes of a trickled reply.")
+	launchServerCmd.Flags().IntVar(&faults.RefuseAfter, "refuse-after", 0, "Refuse every connection after this many were accepted.")
+	launchServerCmd.Flags().Int64Var(&faults.Seed, "fault-seed", 0, "The seed of the random faults, the same seed repeats the same faults.")
+}
+
+// The fault flags, with how each one overrides its setting in a --faults file.
+var faultFlags = map[string]func(config *utils.FaultConfig){
+	"latency":           func(config *utils.FaultConfig) { config.Latency = faults.Latency },
+	"jitter":            func(config *utils.FaultConfig) { config.Jitter = faults.Jitter },
+	"latency-chance":    func(config *utils.FaultConfig) { config.LatencyChance = faults.LatencyChance },
+	"partial-chance":    func(config *utils.FaultConfig) { config.PartialChance = faults.PartialChance },
+	"corrupt-chance":    func(config *utils.FaultConfig) { config.CorruptChance = faults.CorruptChance },
+	"reset-chance":      func(config *utils.FaultConfig) { config.ResetChance = faults.ResetChance },
+	"half-close-chance": func(config *utils.FaultConfig) { config.HalfCloseChance = faults.HalfCloseChance },
+	"trickle-chance":    func(config *utils.FaultConfig) { config.TrickleChance = faults.TrickleChance },
+	"trickle-delay":     func(config *utils.FaultConfig) { config.TrickleDelay = faults.TrickleDelay },
+	"refuse-after":      func(config *utils.FaultConfig) { config.RefuseAfter = faults.RefuseAfter },
+	"fault-seed":        func(config *utils.FaultConfig) { config.Seed = faults.Seed },
+}
+
+// This function builds the faults to inject from the --faults file and the fault flags, nil when there are none.
+func faultOptions(cmd *cobra.Command) (*utils.FaultConfig, error) {
+	// Flags alone keep the defaults of the flags they did not set.
+	config := faults
+	if faultsFile != "" {
+		var err error
+		if config, err = utils.LoadFaultConfig(faultsFile); err != nil {
+			return nil, err
+		}
+	}
+	flagged := false
+	cmd.Flags().Visit(func(flag *pflag.Flag) {
+		if override, ok := faultFlags[flag.Name]; ok {
+			override(&config)
+			flagged = true
+		}
+	})
+	if faultsFile == "" && !flagged {
+		return nil, nil
+	}
+	if err := utils.ValidateFaults(config); err != nil {
+		return nil, err
+	}
+	return &config, nil
 }
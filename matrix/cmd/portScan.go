@@ -16,52 +16,171 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"matrix/pkg/scan"
 	"matrix/pkg/utils"
 	"os"
 	"sort"
-	"text/tabwriter"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 )
 
 var (
-	hostname  string
-	startPort int
-	endPort   int
+	hostname     string
+	targetsFile  string
+	startPort    int
+	endPort      int
+	portSpec     string
+	excludePorts string
+	udpScan      bool
+	concurrency  int
+	scanTimeout  time.Duration
+	scanRate     int
+	adaptiveWait bool
+	showStates   []string
+	synScan      bool
+	probeAll     bool
 )
 
 // portScanCmd represents the portScan command
 var portScanCmd = &cobra.Command{
 	Use:   "portScan",
 	Short: "Discover open ports on a given network host.",
-	Long: `The scan port command performs a TCP connect scan to all the ports on the given host. 
+	Long: `The scan port command performs a TCP connect scan to all the ports on the given hosts. 
+	Hosts can be given as names, addresses, CIDR blocks (10.0.0.0/24) or ranges (10.0.0.1-50), separated by commas.
+	Ports can be given as a list (22,80,443,8000-8100) or a named set (top100, top1000, web, db) with --ports.
 	Such scans simply tries to connect with the given ports on the machine and checks if they are open or not.
+	A refused connection marks the port Closed, a timeout or ICMP unreachable marks it Filtered.
+	Open TCP ports are then probed to find out the service and version listening on them.
+	The SYN scan (--syn) only sends the first packet of the TCP handshake and never completes it, which is faster and quieter
+	but needs superuser access and skips service detection.
+	In UDP mode a protocol specific probe is sent to each port instead and the reply (or the lack of it) decides the port state.
 	This scan has been implemented in parallel fashion to make it quick.
+	With --interval the scan is repeated to keep an eye on the hosts, only the ports that open and close are printed.
 	`,
-	Run: func(cmd *cobra.Command, args []string) {
-		// Gather TCP scan results and clean them.
-		tcpResults := utils.ScanHostPorts(hostname, startPort, endPort)
-		sort.SliceStable(tcpResults, func(i, j int) bool {
-			return tcpResults[i].Port < tcpResults[j].Port
-		})
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		options := scan.PortOptions{
+			TargetsFile:  targetsFile,
+			Ports:        portSpec,
+			ExcludePorts: excludePorts,
+			UDP:          udpScan,
+			Syn:          synScan,
+			Concurrency:  concurrency,
+			Timeout:      scanTimeout,
+			Rate:         scanRate,
+			FixedTimeout: !adaptiveWait,
+			ProbeAll:     probeAll,
+			Names:        nameOptions(),
+		}
+		// The default host is only scanned when the user gave no other targets.
+		if targetsFile == "" || cmd.Flags().Changed("hostname") {
+			options.Targets = []string{hostname}
+		}
+		// An explicit port list wins over the start and end ports.
+		if options.Ports == "" {
+			options.Ports = fmt.Sprintf("%d-%d", startPort, endPort)
+		}
+		if err := utils.ValidatePortStates(showStates); err != nil {
+			return err
+		}
+		if err := validateWatch(); err != nil {
+			return err
+		}
+		if concurrency > utils.CONCURRENCY_CEILING {
+			fmt.Fprintf(os.Stderr, "Concurrency capped at %d, be nice to the network.\n", utils.CONCURRENCY_CEILING)
+		}
+
+		output, err := openOutput()
+		if err != nil {
+			return err
+		}
+		defer closeOutput(output, &err)
+
+		// Open ports are always reported, the rest only when the user asks for them.
+		// The scan is saved and watched with the same ports, the open ones at least.
+		shownStates := append([]string{"open"}, showStates...)
+		target := append([]string{}, options.Targets...)
+		if targetsFile != "" {
+			target = append(target, targetsFile)
+		}
+
+		// One round of the scan, the ports are streamed as they are found when asked to.
+		var streamErr error
+		runScan := func(ctx context.Context, stream bool) (utils.SavedScan, error) {
+			progress := newProgress("ports", "open")
+			options.OnStart = func(hosts []string, ports []int) {
+				progress.Start(len(hosts)*len(ports), time.Time{})
+			}
+			options.OnResult = func(host string, result scan.PortResult) {
+				progress.Step(result.State == "Open")
+				if stream && streamErr == nil && utils.MatchPortState(result.State, shownStates) {
+					streamErr = utils.StreamPortResult(output, host, result)
+				}
+			}
+
+			// Gather scan results and clean them.
+			startTime := time.Now()
+			scanResults, err := scan.Ports(ctx, options)
+			progress.Stop()
+			scanResults = utils.FilterPortStates(scanResults, shownStates)
+			return utils.SavedScan{Kind: "portScan", Target: strings.Join(target, ","), Started: startTime, Finished: time.Now(), Ports: scanResults}, err
+		}
+		if watchInterval > 0 {
+			return watchScans(cmd.Context(), output, runScan)
+		}
 
-		// Print the results in a clean fashion.
-		writer := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', tabwriter.AlignRight|tabwriter.Debug)
-		fmt.Fprintln(writer, "Port\tState\tService")
-		fmt.Fprintln(writer, "----------------------")
-		for _, result := range tcpResults {
-			if result.State == "Open" {
-				fmt.Fprintf(writer, "%d\t%s\t%s\n", result.Port, result.State, result.Service)
+		saved, err := runScan(cmd.Context(), streamOutput)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			return err
+		}
+		if saveErr := saveScan(saved, err != nil); saveErr != nil {
+			return saveErr
+		}
+		if streamOutput {
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Scan interrupted.")
 			}
+			return streamErr
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Scan interrupted, showing the results gathered so far.")
 		}
-		writer.Flush()
+
+		return utils.WritePortResults(output, outputFormat, saved.Ports, saved.Started)
 	},
 }
 
+// This function lists the named port sets for the help message.
+func presetNames() []string {
+	var names []string
+	for name := range utils.PortPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func init() {
 	rootCmd.AddCommand(portScanCmd)
-	portScanCmd.Flags().StringVarP(&hostname, "hostname", "H", "localhost", "The host you want to scan.")
+	portScanCmd.Flags().StringVarP(&hostname, "hostname", "H", "localhost", "The hosts you want to scan as a comma separated list of names, addresses, CIDR blocks or ranges.")
+	portScanCmd.Flags().StringVar(&targetsFile, "targets-file", "", "A file listing more hosts to scan, one or more per line.")
 	portScanCmd.Flags().IntVarP(&startPort, "start_port", "s", 1, "Start number of the port you want to scan.")
 	portScanCmd.Flags().IntVarP(&endPort, "end_port", "e", 1024, "The port number you want to stop scanning at.")
+	portScanCmd.Flags().StringVarP(&portSpec, "ports", "p", "", "The ports you want to scan, for example 22,80,443,8000-8100 or a named set: "+strings.Join(presetNames(), ", ")+".")
+	portScanCmd.Flags().StringVar(&excludePorts, "exclude-ports", "", "The ports you do not want to scan, written like --ports.")
+	portScanCmd.Flags().BoolVarP(&udpScan, "udp", "u", false, "Scan UDP ports instead of TCP ports.")
+	portScanCmd.Flags().IntVarP(&concurrency, "concurrency", "c", utils.SANITY_LIMIT, fmt.Sprintf("The number of ports scanned at once, capped at %d.", utils.CONCURRENCY_CEILING))
+	portScanCmd.Flags().DurationVarP(&scanTimeout, "timeout", "t", 0, "How long to wait for a port to answer. Default is 10s for TCP, 1s for SYN and 2s for UDP.")
+	portScanCmd.Flags().IntVarP(&scanRate, "rate", "r", 0, "The most connection attempts made per second. Default is no limit.")
+	portScanCmd.Flags().BoolVar(&synScan, "syn", false, "Perform a half open SYN scan instead of a full connect scan. Needs superuser access.")
+	portScanCmd.Flags().BoolVar(&probeAll, "probe-all", false, "Send every service probe (HTTP, TLS, Redis) to every silent open port, not just to the ports they are meant for.")
+	portScanCmd.Flags().StringSliceVar(&showStates, "show", nil, "Also report ports in these states: "+strings.Join(utils.PortStates, ", ")+". Open ports are always reported.")
+	portScanCmd.Flags().BoolVar(&adaptiveWait, "adaptive-timeout", true, "Shorten the timeout to match the round trip time of hosts that answer.")
+	addNameFlags(portScanCmd)
+	addSaveFlag(portScanCmd)
+	addWatchFlags(portScanCmd)
 }
@@ -16,11 +16,42 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"matrix/pkg/scan"
+	"matrix/pkg/utils"
 	"os"
+	"os/signal"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 )
 
+var (
+	outputFormat string
+	outputFile   string
+	streamOutput bool
+	noNames      bool
+	dnsServer    string
+	dnsTimeout   time.Duration
+	mdnsNames    bool
+	netbiosNames bool
+	saveResults  bool
+	storeDir     string
+	useTLS       bool
+	tlsOptions   utils.TLSOptions
+)
+
+// An error which only sets the exit code, the command has already said everything there is to say.
+type exitCode int
+
+func (code exitCode) Error() string {
+	return fmt.Sprintf("exit code %d", int(code))
+}
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "matrix",
@@ -40,8 +71,140 @@ var rootCmd = &cobra.Command{
 	`,
 }
 
+// Standard output is shared with everyone else, so closing the scan results must leave it open.
+type stdoutWriter struct {
+	io.Writer
+}
+
+func (stdoutWriter) Close() error {
+	return nil
+}
+
+// This function opens the destination for the scan results.
+// The results go to standard output unless the user asked for an output file.
+func openOutput() (io.WriteCloser, error) {
+	if err := utils.ValidateOutputFormat(outputFormat); err != nil {
+		return nil, err
+	}
+	// Streamed results are written one at a time, which only works for a format made of independent lines.
+	if streamOutput {
+		if rootCmd.PersistentFlags().Lookup("output").Changed && outputFormat != "ndjson" {
+			return nil, fmt.Errorf("--stream writes ndjson, it cannot be combined with --output %s", outputFormat)
+		}
+		outputFormat = "ndjson"
+	}
+	if outputFile == "" {
+		return stdoutWriter{os.Stdout}, nil
+	}
+	return os.Create(outputFile)
+}
+
+// This function closes the scan results and reports a failed close, a full disk only shows up at this point.
+func closeOutput(output io.Closer, err *error) {
+	if closeErr := output.Close(); *err == nil {
+		*err = closeErr
+	}
+}
+
+// This function creates the live progress line of a scan, or nil when nobody would see it.
+// The line is drawn on the terminal of standard error and would get torn apart by results streamed to the same terminal.
+func newProgress(label string, foundLabel string) *utils.Progress {
+	if !utils.IsTerminal(os.Stderr) || (streamOutput && outputFile == "" && utils.IsTerminal(os.Stdout)) {
+		return nil
+	}
+	return utils.NewProgress(os.Stderr, label, foundLabel)
+}
+
+// This function adds the flags deciding how a scan looks up the names of the hosts it found.
+func addNameFlags(command *cobra.Command) {
+	command.Flags().BoolVar(&noNames, "no-dns", false, "Do not look up the names of the hosts found.")
+	command.Flags().StringVar(&dnsServer, "dns-server", "", "The DNS server asked for the names of the hosts, such as 1.1.1.1 or [2606:4700::1111]:53. Default is the one of the system.")
+	command.Flags().DurationVar(&dnsTimeout, "dns-timeout", utils.RESOLVER_TIMEOUT, "How long a single name lookup may take.")
+	command.Flags().BoolVar(&mdnsNames, "mdns", false, "Ask hosts without a DNS name for their multicast DNS name.")
+	command.Flags().BoolVar(&netbiosNames, "netbios", false, "Ask hosts without a DNS name for their NetBIOS name.")
+}
+
+// This function adds the flag saving a scan for later comparison.
+func addSaveFlag(command *cobra.Command) {
+	command.Flags().BoolVar(&saveResults, "save", false, "Save the results to the scan store, for comparing them with matrix diff.")
+}
+
+// This function adds the TLS flags of the test servers and clients, the CA verifies the clients on a server
+// and the server on a client.
+func addTLSFlags(command *cobra.Command, server bool) {
+	command.Flags().BoolVar(&useTLS, "tls", false, "Talk TLS, --cert, --key and --ca turn it on as well.")
+	command.Flags().StringVar(&tlsOptions.CertFile, "cert", "", "The PEM certificate to present.")
+	command.Flags().StringVar(&tlsOptions.KeyFile, "key", "", "The PEM private key of the certificate.")
+	if server {
+		command.Flags().StringVar(&tlsOptions.CAFile, "ca", "", "Require client certificates signed by the CAs in this PEM file (mutual TLS).")
+		return
+	}
+	command.Flags().StringVar(&tlsOptions.CAFile, "ca", "", "Trust the server certificates signed by the CAs in this PEM file.")
+	command.Flags().BoolVar(&tlsOptions.Insecure, "insecure", false, "Accept any server certificate, turns TLS on as well.")
+}
+
+// This function tells whether the TLS flags ask for TLS.
+func tlsRequested() bool {
+	return useTLS || tlsOptions != utils.TLSOptions{}
+}
+
+// This function checks that UDP mode goes with nothing it cannot do, there is no TLS or websocket over UDP.
+func validateUDP(udp bool, websocket bool, group string) error {
+	switch {
+	case udp && websocket:
+		return errors.New("choose either --udp or --wsmode")
+	case udp && tlsRequested():
+		return errors.New("TLS needs a stream, it cannot be used with --udp")
+	case !udp && group != "":
+		return errors.New("--group needs --udp")
+	}
+	return nil
+}
+
+// This function collects the name lookup flags for the scan.
+func nameOptions() scan.NameOptions {
+	return scan.NameOptions{Disabled: noNames, Server: dnsServer, Timeout: dnsTimeout, MDNS: mdnsNames, NetBIOS: netbiosNames}
+}
+
+// This function returns the directory the scans are saved in.
+func storeDirectory() (string, error) {
+	if storeDir != "" {
+		return storeDir, nil
+	}
+	return utils.DefaultStoreDir()
+}
+
+// This function saves a finished scan to the store when the user asked for it.
+// An interrupted scan is left out, every host it never reached would look gone in the next diff.
+func saveScan(scan utils.SavedScan, interrupted bool) error {
+	if !saveResults {
+		return nil
+	}
+	if interrupted {
+		fmt.Fprintln(os.Stderr, "The scan was interrupted and is not saved.")
+		return nil
+	}
+	directory, err := storeDirectory()
+	if err != nil {
+		return err
+	}
+	id, err := utils.SaveScan(directory, scan)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Scan saved as %s, compare it with the next one using: matrix diff\n", id)
+	return nil
+}
+
 func Execute() {
-	err := rootCmd.Execute()
+	// Ctrl-C cancels the running scan instead of killing the program, so the results found so far still get written.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	err := rootCmd.ExecuteContext(ctx)
+	var code exitCode
+	if errors.As(err, &code) {
+		os.Exit(int(code))
+	}
 	if err != nil {
 		os.Exit(1)
 	}
@@ -49,4 +212,8 @@ func Execute() {
 
 func init() {
 	rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	rootCmd.PersistentFlags().StringVarP(&outputFormat, "output", "o", "table", "The format of the scan results: "+strings.Join(utils.OutputFormats, ", ")+".")
+	rootCmd.PersistentFlags().StringVar(&outputFile, "outfile", "", "Write the scan results to this file instead of the terminal.")
+	rootCmd.PersistentFlags().BoolVar(&streamOutput, "stream", false, "Write every result as an ndjson line as soon as it is found, instead of all of them at the end.")
+	rootCmd.PersistentFlags().StringVar(&storeDir, "store", "", "The directory the scans are saved in and compared from. Default is ~/"+utils.SCAN_STORE_DIR+".")
 }
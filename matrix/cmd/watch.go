@@ -0,0 +1,117 @@
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"matrix/pkg/utils"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	watchInterval time.Duration
+	webhookURL    string
+	changeHook    string
+)
+
+// This function adds the flags repeating a scan and reporting what changed.
+func addWatchFlags(command *cobra.Command) {
+	command.Flags().DurationVar(&watchInterval, "interval", 0, "Repeat the scan with this pause in between, such as 10m, and only print what changed. Stop with Ctrl-C.")
+	command.Flags().StringVar(&webhookURL, "webhook", "", "With --interval, post the changes as JSON to this URL.")
+	command.Flags().StringVar(&changeHook, "on-change", "", "With --interval, run this shell command with the changes as JSON on its standard input.")
+}
+
+// This function checks the watch flags before the first scan starts.
+func validateWatch() error {
+	if watchInterval < 0 {
+		return fmt.Errorf("the interval cannot be negative")
+	}
+	if watchInterval == 0 {
+		if webhookURL != "" || changeHook != "" {
+			return errors.New("--webhook and --on-change report the changes between repeated scans, they need --interval")
+		}
+		return nil
+	}
+	if streamOutput {
+		return errors.New("--interval prints only what changed, it cannot be combined with --stream")
+	}
+	if outputFormat != "table" && outputFormat != "json" && outputFormat != "ndjson" {
+		return fmt.Errorf("--interval writes table, json or ndjson, not %s", outputFormat)
+	}
+	return nil
+}
+
+/*
+Watch mode.
+The scan is repeated until the user stops it, every round is compared with the one before and only the changes are written.
+The first round is compared with an empty scan, so everything found is reported as new.
+A webhook or hook that fails is reported and the watch goes on, a monitor should not die with the network it watches.
+*/
+func watchScans(ctx context.Context, output io.Writer, runScan func(ctx context.Context, stream bool) (utils.SavedScan, error)) error {
+	var previous *utils.SavedScan
+	for {
+		current, err := runScan(ctx, false)
+		if ctx.Err() != nil {
+			fmt.Fprintln(os.Stderr, "Watch stopped.")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := saveScan(current, false); err != nil {
+			return err
+		}
+		if previous == nil {
+			previous = &utils.SavedScan{Kind: current.Kind, Target: current.Target}
+		}
+		changes, err := utils.DiffScans(*previous, current)
+		if err != nil {
+			return err
+		}
+		previous = &current
+
+		if len(changes) > 0 {
+			event := utils.ChangeEvent{Kind: current.Kind, Target: current.Target, Time: current.Finished, Changes: changes}
+			if err := utils.WriteChangeEvent(output, outputFormat, event); err != nil {
+				return err
+			}
+			if webhookURL != "" {
+				if err := utils.PostWebhook(ctx, webhookURL, event); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+				}
+			}
+			if changeHook != "" {
+				if err := utils.RunHook(ctx, changeHook, event); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+				}
+			}
+		}
+
+		fmt.Fprintf(os.Stderr, "Next scan at %s.\n", time.Now().Add(watchInterval).Format("15:04:05"))
+		select {
+		case <-time.After(watchInterval):
+		case <-ctx.Done():
+			fmt.Fprintln(os.Stderr, "Watch stopped.")
+			return nil
+		}
+	}
+}
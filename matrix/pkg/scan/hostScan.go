@@ -0,0 +1,102 @@
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package scan
+
+import (
+	"context"
+	"matrix/pkg/utils"
+	"time"
+)
+
+// How long we wait for a host to answer when no time is given.
+const DEFAULT_PING_TIME = 10 * time.Second
+
+type Host = utils.IpData
+
+// The settings of a host discovery scan.
+type HostOptions struct {
+	// The CIDR notation of the network to scan.
+	Network string
+	// How long to wait for every host to answer, DEFAULT_PING_TIME when zero.
+	// The scan is over as soon as every host has answered or run out of time.
+	PingTime time.Duration
+	// How many more times the icmp and arp methods ask a host that did not answer, none when zero.
+	Retries int
+	// The interface used for ARP and for IPv6 networks too large to ping address by address.
+	// Large IPv6 networks are found by pinging the all-nodes group of the link, which is found from the network when this is empty.
+	Interface string
+	// The ways hosts are looked for: icmp, tcp, syn, udp and arp. Only icmp is used when empty.
+	// A host is up as soon as one of them gets an answer, the method that did is recorded with the host.
+	// tcp needs no special rights, arp also reports the MAC address and vendor of hosts on a directly connected network.
+	Methods []string
+	// The ports knocked on by the tcp and syn methods and by the udp method, written like PortOptions.Ports.
+	// Sensible defaults are used when empty.
+	TCPPorts string
+	UDPPorts string
+	// How many times the icmp method pings every host, once when zero.
+	// More than once fills in the packet loss and round trip statistics of every host, one round goes out every second.
+	Count int
+	// Also return the hosts that did not answer at all, with the state Down.
+	ReportDown bool
+	// How the names of the hosts that answered are looked up.
+	Names NameOptions
+
+	// Called once before the first ping goes out, with the number of addresses about to be pinged and the longest the scan can take.
+	// The number is zero when the all-nodes group is pinged instead, nobody knows how many hosts will answer.
+	OnStart func(addresses int, longest time.Duration)
+	// Called with every host as soon as it answers.
+	OnHost func(host Host)
+}
+
+/*
+Main host discovery function.
+Every address of the network is probed with the chosen methods and the hosts that answered are returned.
+When the context is cancelled the scan stops and the hosts found so far are returned with the error of the context.
+*/
+func Hosts(ctx context.Context, options HostOptions) ([]Host, error) {
+	pingTime := options.PingTime
+	if pingTime <= 0 {
+		pingTime = DEFAULT_PING_TIME
+	}
+	config := utils.HostScanConfig{
+		PingTimeout: pingTime,
+		Retries:     options.Retries,
+		Interface:   options.Interface,
+		Methods:     options.Methods,
+		Count:       options.Count,
+		ReportDown:  options.ReportDown,
+		Names:       options.Names,
+	}
+	var err error
+	if options.TCPPorts != "" {
+		if config.TCPPorts, err = utils.ParsePorts(options.TCPPorts, ""); err != nil {
+			return nil, err
+		}
+	}
+	if options.UDPPorts != "" {
+		if config.UDPPorts, err = utils.ParsePorts(options.UDPPorts, ""); err != nil {
+			return nil, err
+		}
+	}
+	if options.OnStart != nil {
+		addresses, err := utils.NetworkSize(options.Network, options.Interface)
+		if err != nil {
+			return nil, err
+		}
+		options.OnStart(addresses, utils.MaxDiscoveryTime(addresses, config))
+	}
+	return utils.DiscoverHosts(ctx, options.Network, config, options.OnHost)
+}
@@ -0,0 +1,44 @@
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package scan
+
+import (
+	"context"
+	"matrix/pkg/utils"
+	"testing"
+	"time"
+)
+
+func TestHostOptionDefaults(t *testing.T) {
+	// The scan is cancelled before it starts, only the time it announces matters.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var longest time.Duration
+	options := HostOptions{
+		Network: "127.0.0.1/32",
+		Methods: []string{"tcp"},
+		OnStart: func(addresses int, duration time.Duration) { longest = duration },
+	}
+	Hosts(ctx, options)
+	want := utils.MaxDiscoveryTime(1, utils.HostScanConfig{PingTimeout: DEFAULT_PING_TIME, Methods: []string{"tcp"}})
+	if longest != want {
+		t.Errorf("a scan without a ping time announced %v, want %v", longest, want)
+	}
+
+	if _, err := Hosts(ctx, HostOptions{Network: "127.0.0.1/32", TCPPorts: "80-"}); err == nil {
+		t.Error("Hosts() took a bad list of TCP ports")
+	}
+}
@@ -0,0 +1,131 @@
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package scan lets other Go programs run the matrix scanners.
+// Nothing in here prints, exits or shows progress, errors are returned and results can be streamed through callbacks.
+package scan
+
+import (
+	"context"
+	"errors"
+	"matrix/pkg/utils"
+	"strings"
+	"time"
+)
+
+// The ports scanned when no port list is given.
+const DEFAULT_PORTS = "1-1024"
+
+type PortResult = utils.ScanResult
+type HostPorts = utils.HostPorts
+
+/*
+How the names of the hosts found are looked up.
+DNS is asked first, through Server when one is given, and with MDNS or NetBIOS the host itself after that.
+Every lookup gives up after Timeout, utils.RESOLVER_TIMEOUT when zero.
+*/
+type NameOptions = utils.ResolverConfig
+
+// The settings of a port scan, the zero value of every field picks a sensible default.
+type PortOptions struct {
+	// Names, addresses, CIDR blocks (10.0.0.0/24) or ranges (10.0.0.1-50).
+	Targets []string
+	// A file listing more targets, one or more per line.
+	TargetsFile string
+	// A port list such as "22,80,8000-8100,web", DEFAULT_PORTS when empty.
+	Ports string
+	// Ports to leave out, written like Ports.
+	ExcludePorts string
+
+	UDP         bool
+	Syn         bool
+	Concurrency int
+	Timeout     time.Duration
+	Rate        int
+	// Try every service probe on every open port, not just the probes meant for that port.
+	ProbeAll bool
+	// Always wait the full Timeout instead of following the round trip time of the host.
+	FixedTimeout bool
+	// How the names of the hosts given by address are looked up once the scan is over.
+	Names NameOptions
+
+	// Called once before the first probe goes out, with the hosts and ports about to be scanned.
+	OnStart func(hosts []string, ports []int)
+	// Called with every port as soon as its state is known, never from two goroutines at once.
+	OnResult func(host string, result PortResult)
+}
+
+// This function checks the options and turns them into what the scanner works with.
+func (options PortOptions) prepare() ([]string, []int, utils.PortScanConfig, error) {
+	config := utils.PortScanConfig{
+		Protocol:        "tcp",
+		Concurrency:     options.Concurrency,
+		Timeout:         options.Timeout,
+		Rate:            options.Rate,
+		AdaptiveTimeout: !options.FixedTimeout,
+		SynScan:         options.Syn,
+		ProbeAll:        options.ProbeAll,
+	}
+	if options.UDP {
+		config.Protocol = "udp"
+	}
+	if options.Syn {
+		if options.UDP {
+			return nil, nil, config, errors.New("the SYN scan only works for TCP ports")
+		}
+		if err := utils.SynScanAvailable(); err != nil {
+			return nil, nil, config, err
+		}
+	}
+
+	hosts, err := utils.ParseTargets(strings.Join(options.Targets, ","), options.TargetsFile)
+	if err != nil {
+		return nil, nil, config, err
+	}
+	portSpec := options.Ports
+	if portSpec == "" {
+		portSpec = DEFAULT_PORTS
+	}
+	ports, err := utils.ParsePorts(portSpec, options.ExcludePorts)
+	if err != nil {
+		return nil, nil, config, err
+	}
+	return hosts, ports, config, nil
+}
+
+/*
+Main port scan function.
+Every port of every target is scanned and the results come back grouped by host, in the order the targets were given.
+When the context is cancelled the scan stops and the results gathered so far are returned with the error of the context.
+*/
+func Ports(ctx context.Context, options PortOptions) ([]HostPorts, error) {
+	hosts, ports, config, err := options.prepare()
+	if err != nil {
+		return nil, err
+	}
+	resolver, err := utils.NewResolver(options.Names)
+	if err != nil {
+		return nil, err
+	}
+	if options.OnStart != nil {
+		options.OnStart(hosts, ports)
+	}
+	results, err := utils.ScanTargets(ctx, hosts, ports, config, options.OnResult)
+	if err == nil {
+		utils.LookupHostNames(ctx, resolver, results)
+	}
+	return results, err
+}
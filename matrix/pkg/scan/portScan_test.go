@@ -0,0 +1,118 @@
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package scan
+
+import (
+	"context"
+	"fmt"
+	"matrix/pkg/utils"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPortOptionDefaults(t *testing.T) {
+	hosts, ports, config, err := PortOptions{Targets: []string{"192.0.2.1"}}.prepare()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(hosts, []string{"192.0.2.1"}) {
+		t.Errorf("prepare() gave the hosts %v", hosts)
+	}
+	// DEFAULT_PORTS is every port up to 1024.
+	if len(ports) != 1024 || ports[0] != 1 || ports[len(ports)-1] != 1024 {
+		t.Errorf("prepare() gave %d ports from %v to %v, want 1-1024", len(ports), ports[0], ports[len(ports)-1])
+	}
+	// A zero timeout is left to the scanner, which follows the round trip time of the host.
+	want := utils.PortScanConfig{Protocol: "tcp", AdaptiveTimeout: true}
+	if config != want {
+		t.Errorf("prepare() gave the config %+v, want %+v", config, want)
+	}
+
+	options := PortOptions{Targets: []string{"192.0.2.1"}, Ports: "80", UDP: true, Timeout: 3 * time.Second, FixedTimeout: true, Concurrency: 5, Rate: 100}
+	_, ports, config, err = options.prepare()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = utils.PortScanConfig{Protocol: "udp", Concurrency: 5, Timeout: 3 * time.Second, Rate: 100}
+	if !reflect.DeepEqual(ports, []int{80}) || config != want {
+		t.Errorf("prepare() gave the ports %v and the config %+v, want [80] and %+v", ports, config, want)
+	}
+}
+
+func TestPortOptionErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		options PortOptions
+	}{
+		{"no targets", PortOptions{}},
+		{"bad ports", PortOptions{Targets: []string{"192.0.2.1"}, Ports: "80-"}},
+		{"syn over udp", PortOptions{Targets: []string{"192.0.2.1"}, UDP: true, Syn: true}},
+	}
+	for _, test := range tests {
+		if _, _, _, err := test.options.prepare(); err == nil {
+			t.Errorf("%s: prepare() gave no error", test.name)
+		}
+	}
+}
+
+func TestPorts(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	// Nothing listens on the port of a listener which is closed again.
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+	openPort := listener.Addr().(*net.TCPAddr).Port
+
+	var started []int
+	streamed := map[int]string{}
+	options := PortOptions{
+		Targets: []string{"127.0.0.1"},
+		Ports:   fmt.Sprintf("%d,%d", openPort, closedPort),
+		Timeout: 2 * time.Second,
+		Names:   NameOptions{Disabled: true},
+		OnStart: func(hosts []string, ports []int) { started = ports },
+		OnResult: func(host string, result PortResult) {
+			streamed[result.Port] = result.State
+		},
+	}
+	results, err := Ports(context.Background(), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(started) != 2 {
+		t.Errorf("OnStart() was given the ports %v", started)
+	}
+	if len(results) != 1 || results[0].Host != "127.0.0.1" {
+		t.Fatalf("Ports() = %+v, want the results of 127.0.0.1", results)
+	}
+	states := map[int]string{}
+	for _, result := range results[0].Ports {
+		states[result.Port] = result.State
+	}
+	want := map[int]string{openPort: "Open", closedPort: "Closed"}
+	if !reflect.DeepEqual(states, want) || !reflect.DeepEqual(streamed, want) {
+		t.Errorf("Ports() found %v and streamed %v, want %v", states, streamed, want)
+	}
+}
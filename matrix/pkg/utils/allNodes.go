@@ -0,0 +1,106 @@
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+)
+
+/*
+IPv6 neighbor discovery.
+A /64 holds more addresses than could ever be pinged, but every host on a link listens to the all-nodes group ff02::1.
+A single echo request to the group is answered by all of them, from their link-local addresses.
+*/
+var allNodes = net.ParseIP("ff02::1")
+
+// This function finds the local link of a network, the one interface with an address inside it.
+// Every interface has a link-local address, so link-local networks need the interface to be named.
+func linkFor(prefix netip.Prefix, interfaceName string) (*net.Interface, error) {
+	if interfaceName != "" {
+		return net.InterfaceByName(interfaceName)
+	}
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var links []net.Interface
+	for _, link := range interfaces {
+		if link.Flags&net.FlagUp == 0 || link.Flags&net.FlagMulticast == 0 {
+			continue
+		}
+		addresses, err := link.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, address := range addresses {
+			network, ok := address.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			local, ok := netip.AddrFromSlice(network.IP)
+			if ok && prefix.Contains(local) {
+				links = append(links, link)
+				break
+			}
+		}
+	}
+	switch len(links) {
+	case 0:
+		return nil, fmt.Errorf("no local interface has an address in %s", prefix)
+	case 1:
+		return &links[0], nil
+	}
+	return nil, fmt.Errorf("%d interfaces have an address in %s, choose one with --interface", len(links), prefix)
+}
+
+// This function pings the all-nodes group of the link and passes every host that answers to the output formatter.
+// It works like pingSender, but nobody knows who is out there so it always listens until the time is up.
+func allNodesSender(ctx context.Context, pinger echoSocket, link *net.Interface, pingResultChannel chan<- pingResult, pingTimeout time.Duration) error {
+	replies := make(chan echoReply)
+	pinger.receive(replies)
+
+	// The zone sends the request out of the right link.
+	sent := time.Now()
+	if err := pinger.send(&net.IPAddr{IP: allNodes, Zone: link.Name}, 0); err != nil {
+		return err
+	}
+
+	timer := time.NewTimer(pingTimeout)
+	defer timer.Stop()
+	seen := map[string]bool{}
+	for {
+		select {
+		case reply := <-replies:
+			if seen[reply.address.String()] {
+				continue
+			}
+			seen[reply.address.String()] = true
+			select {
+			case pingResultChannel <- pingResult{ipAddress: reply.address, ipState: "Up", responseTime: reply.at.Sub(sent), method: "icmp", reason: "echo-reply"}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		case <-timer.C:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
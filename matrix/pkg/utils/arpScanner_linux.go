@@ -0,0 +1,191 @@
+//go:build linux
+
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+// How long a single read of the ARP socket blocks before we look at the clock and the context again.
+const ARP_READ_INTERVAL = 100 * time.Millisecond
+
+// The ARP operations.
+const (
+	ARP_REQUEST = 1
+	ARP_REPLY   = 2
+)
+
+/*
+ARP discovery.
+A host may ignore pings but it cannot ignore ARP, without answering who-has requests nobody on its segment could reach it.
+The requests go out on a packet socket, so this only works for networks directly connected to one of our interfaces.
+*/
+
+// The sockets speak network byte order, the syscall package hands us host byte order constants.
+func htons(value uint16) uint16 {
+	return value<<8 | value>>8
+}
+
+// This function finds the interface directly connected to the addresses, along with our own address on it.
+func arpLinkFor(ips []net.IP, interfaceName string) (*net.Interface, net.IP, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, nil, err
+	}
+	for index := range interfaces {
+		link := &interfaces[index]
+		if interfaceName != "" && link.Name != interfaceName {
+			continue
+		}
+		if link.Flags&net.FlagUp == 0 || link.Flags&net.FlagLoopback != 0 || len(link.HardwareAddr) != 6 {
+			continue
+		}
+		addresses, err := link.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, address := range addresses {
+			network, ok := address.(*net.IPNet)
+			if ok && network.IP.To4() != nil && network.Contains(ips[0]) && network.Contains(ips[len(ips)-1]) {
+				return link, network.IP.To4(), nil
+			}
+		}
+	}
+	if interfaceName != "" {
+		return nil, nil, fmt.Errorf("interface %s is not directly connected to %s-%s", interfaceName, ips[0], ips[len(ips)-1])
+	}
+	return nil, nil, fmt.Errorf("no interface is directly connected to %s-%s, ARP only reaches the local segment", ips[0], ips[len(ips)-1])
+}
+
+// This function builds a who-has request for the target address.
+func arpRequest(link *net.Interface, source net.IP, target net.IP) []byte {
+	packet := make([]byte, 28)
+	binary.BigEndian.PutUint16(packet[0:2], 1)      // Ethernet
+	binary.BigEndian.PutUint16(packet[2:4], 0x0800) // IPv4
+	packet[4] = 6
+	packet[5] = 4
+	binary.BigEndian.PutUint16(packet[6:8], ARP_REQUEST)
+	copy(packet[8:14], link.HardwareAddr)
+	copy(packet[14:18], source)
+	// The target hardware address is what we are asking for and stays zero.
+	copy(packet[24:28], target.To4())
+	return packet
+}
+
+// This function asks every address of the network who it is and passes every host that answers to the output formatter.
+// It works like pingSender, it returns once everyone has answered or the retries are used up.
+func arpSender(ctx context.Context, ipsToScan []net.IP, interfaceName string, retries int, pingResultChannel chan<- pingResult, pingTimeout time.Duration) error {
+	var targets []net.IP
+	for _, ip := range ipsToScan {
+		if ip.To4() != nil {
+			targets = append(targets, ip.To4())
+		}
+	}
+	if len(targets) == 0 {
+		return errors.New("ARP discovery only works for IPv4 networks")
+	}
+	link, source, err := arpLinkFor(targets, interfaceName)
+	if err != nil {
+		return err
+	}
+
+	// A datagram packet socket lets the kernel write the Ethernet header for us.
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_DGRAM, int(htons(syscall.ETH_P_ARP)))
+	if err != nil {
+		return fmt.Errorf("ARP discovery needs superuser access or the CAP_NET_RAW capability: %w", err)
+	}
+	defer syscall.Close(fd)
+	if err := syscall.Bind(fd, &syscall.SockaddrLinklayer{Protocol: htons(syscall.ETH_P_ARP), Ifindex: link.Index}); err != nil {
+		return err
+	}
+	interval := syscall.NsecToTimeval(ARP_READ_INTERVAL.Nanoseconds())
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &interval); err != nil {
+		return err
+	}
+
+	// Remember when each address was asked, the replies are matched against it for the response time.
+	// The addresses still in there once the time is up are asked again, as many times as we retry.
+	asked := map[string]time.Time{}
+	for _, target := range targets {
+		asked[target.String()] = time.Time{}
+	}
+	broadcast := &syscall.SockaddrLinklayer{Protocol: htons(syscall.ETH_P_ARP), Ifindex: link.Index, Halen: 6}
+	copy(broadcast.Addr[:], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	buffer := make([]byte, 1500)
+	for attempt := 0; attempt <= retries && len(asked) > 0; attempt++ {
+		for _, target := range targets {
+			if _, waiting := asked[target.String()]; !waiting {
+				continue
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			asked[target.String()] = time.Now()
+			if err := syscall.Sendto(fd, arpRequest(link, source, target), 0, broadcast); err != nil {
+				return err
+			}
+		}
+
+		// Stop listening as soon as everyone has answered.
+		deadline := time.Now().Add(pingTimeout)
+		for len(asked) > 0 && time.Now().Before(deadline) {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			n, _, err := syscall.Recvfrom(fd, buffer, 0)
+			if err != nil {
+				if err == syscall.EAGAIN || err == syscall.EINTR {
+					continue
+				}
+				return err
+			}
+			if n < 28 || binary.BigEndian.Uint16(buffer[6:8]) != ARP_REPLY {
+				continue
+			}
+			sender := net.IP(append([]byte(nil), buffer[14:18]...))
+			sentAt, found := asked[sender.String()]
+			if !found {
+				continue
+			}
+			// Answer every host only once, some of them repeat themselves.
+			delete(asked, sender.String())
+			result := pingResult{
+				ipAddress:    &net.IPAddr{IP: sender},
+				ipState:      "Up",
+				responseTime: time.Since(sentAt),
+				mac:          net.HardwareAddr(append([]byte(nil), buffer[8:14]...)),
+				method:       "arp",
+				reason:       "arp-response",
+			}
+			select {
+			case pingResultChannel <- result:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,31 @@
+//go:build !linux
+
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// Packet sockets are a Linux feature, other platforms would need BPF devices or pcap.
+func arpSender(ctx context.Context, ipsToScan []net.IP, interfaceName string, retries int, pingResultChannel chan<- pingResult, pingTimeout time.Duration) error {
+	return errors.New("ARP discovery is only supported on Linux")
+}
@@ -17,63 +17,289 @@ package utils
 
 import (
 	"bufio"
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"log"
+	"io"
 	"net"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
 )
 
+// How long a client waits for the server to accept its connection.
+const CLIENT_DIAL_TIMEOUT = 10 * time.Second
+
+// How long the UDP client waits for the replies to a datagram when the configuration does not say.
+const DEFAULT_UDP_WAIT = time.Second
+
+// Where the test clients connect to.
+type ClientConfig struct {
+	Host string
+	Port int
+	// The path of the websocket on the server.
+	Path string
+	// The TLS configuration of the client, nil talks plaintext.
+	TLS *tls.Config
+	// How long the UDP client waits for replies, and the interface it joins a multicast group on.
+	Wait      time.Duration
+	Interface string
+}
+
+// A datagram the UDP client received.
+type udpReply struct {
+	peer    net.Addr
+	message []byte
+	at      time.Time
+}
+
 // This function starts a TCP client which connects with the server and allows users to connect test server responses.
-func TcpClient(serverPort int, serverHost string) {
-	serverConnection, err := net.Dial("tcp", serverHost+":"+strconv.Itoa(serverPort))
+func TcpClient(config ClientConfig) error {
+	return tcpClient(config, os.Stdin, os.Stdout)
+}
+
+// This function starts a websocket client.
+func WebsocketClient(config ClientConfig) error {
+	return websocketClient(config, os.Stdin, os.Stdout)
+}
+
+// This function starts a UDP client, sending every line as a datagram and showing the replies with their round trip time.
+func UdpClient(config ClientConfig) error {
+	return udpClient(config, os.Stdin, os.Stdout)
+}
+
+// This function sends the lines of the input to a TCP server and writes its replies to the output.
+func tcpClient(config ClientConfig, input io.Reader, output io.Writer) error {
+	address := net.JoinHostPort(config.Host, strconv.Itoa(config.Port))
+	dialer := &net.Dialer{Timeout: CLIENT_DIAL_TIMEOUT}
+	var serverConnection net.Conn
+	var err error
+	if config.TLS != nil {
+		var tlsConnection *tls.Conn
+		if tlsConnection, err = tls.DialWithDialer(dialer, "tcp", address, config.TLS); err == nil {
+			fmt.Fprintln(output, "Connected with", DescribeTLS(tlsConnection.ConnectionState()))
+			serverConnection = tlsConnection
+		}
+	} else {
+		serverConnection, err = dialer.Dial("tcp", address)
+	}
 	if err != nil {
-		fmt.Println(err)
-		return
+		return err
 	}
+	defer serverConnection.Close()
 
-	fmt.Println("Type in the message you want to send to the server.")
+	// One reader for each side, whatever they buffered belongs to the next line.
+	lines := bufio.NewReader(input)
+	replies := bufio.NewReader(serverConnection)
+	fmt.Fprintln(output, "Type in the message you want to send to the server.")
 	for {
 		// Reading the input from the user to send back to the server.
-		reader := bufio.NewReader(os.Stdin)
-		fmt.Print(">> ")
-		text, _ := reader.ReadString('\n')
-		fmt.Fprintln(serverConnection, text)
+		fmt.Fprint(output, ">> ")
+		text, err := lines.ReadString('\n')
+		if text == "" && err != nil {
+			return nil
+		}
+		if !strings.HasSuffix(text, "\n") {
+			text += "\n"
+		}
+		if _, err := io.WriteString(serverConnection, text); err != nil {
+			return err
+		}
 
 		// Capturing the output from the server and displaying it.
-		message, _ := bufio.NewReader(serverConnection).ReadString('\n')
-		fmt.Print("-> " + message)
+		message, err := replies.ReadString('\n')
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				fmt.Fprintln(output, "Server Closed Connection.")
+				return nil
+			}
+			return err
+		}
+		fmt.Fprint(output, "-> "+message)
 	}
 }
 
-// This function starts a websocket client.
-func WebsocketClient(serverPort int, serverHost string, socketPath string) {
-	socketUrl := "ws://" + serverHost + ":" + strconv.Itoa(serverPort) + socketPath
-	websocketConnection, _, err := websocket.DefaultDialer.Dial(socketUrl, nil)
+// This function sends the lines of the input to a websocket server and writes its replies to the output.
+func websocketClient(config ClientConfig, input io.Reader, output io.Writer) error {
+	scheme := "ws"
+	if config.TLS != nil {
+		scheme = "wss"
+	}
+	socketUrl := scheme + "://" + net.JoinHostPort(config.Host, strconv.Itoa(config.Port)) + config.Path
+	dialer := websocket.Dialer{TLSClientConfig: config.TLS, HandshakeTimeout: CLIENT_DIAL_TIMEOUT}
+	websocketConnection, _, err := dialer.Dial(socketUrl, nil)
 	if err != nil {
-		log.Fatal("Error in connecting with the server: ", err)
+		return fmt.Errorf("error in connecting with the server: %w", err)
 	}
 	defer websocketConnection.Close()
+	if tlsConnection, ok := websocketConnection.UnderlyingConn().(*tls.Conn); ok {
+		fmt.Fprintln(output, "Connected with", DescribeTLS(tlsConnection.ConnectionState()))
+	}
 
+	lines := bufio.NewReader(input)
 	for {
 		// Reading the input from the user to send back to the server.
-		reader := bufio.NewReader(os.Stdin)
-		fmt.Print(">> ")
-		text, _ := reader.ReadString('\n')
-		websocketConnection.WriteMessage(websocket.TextMessage, []byte(text))
+		fmt.Fprint(output, ">> ")
+		text, err := lines.ReadString('\n')
+		if text == "" && err != nil {
+			return nil
+		}
+		if err := websocketConnection.WriteMessage(websocket.TextMessage, []byte(strings.TrimRight(text, "\r\n"))); err != nil {
+			return err
+		}
 
 		// Receiving reply from the server.
 		_, msg, err := websocketConnection.ReadMessage()
 		if err != nil {
-			if fmt.Sprintf("%s", err) == "websocket: close 1006 (abnormal closure): unexpected EOF" {
-				log.Println("Server Closed Connection.")
-				return
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseAbnormalClosure) {
+				fmt.Fprintln(output, "Server Closed Connection.")
+				return nil
+			}
+			return fmt.Errorf("error in receiving the message: %w", err)
+		}
+		fmt.Fprintln(output, "<- ", string(msg))
+	}
+}
+
+/*
+UDP client.
+A unicast server is expected to answer every datagram once, the client moves on with the first reply.
+A datagram to a multicast group can be answered by any number of peers, sometimes to the group itself as discovery
+protocols like mDNS do, so the client joins the group and shows every reply until the wait is over.
+*/
+// This function sends the lines of the input as datagrams and writes the replies to the output.
+func udpClient(config ClientConfig, input io.Reader, output io.Writer) error {
+	if config.Wait <= 0 {
+		config.Wait = DEFAULT_UDP_WAIT
+	}
+	server, err := net.ResolveUDPAddr("udp", net.JoinHostPort(config.Host, strconv.Itoa(config.Port)))
+	if err != nil {
+		return err
+	}
+	// The socket has the family of the server, so the multicast options of that family apply to it.
+	network := "udp6"
+	if server.IP.To4() != nil {
+		network = "udp4"
+	}
+	connection, err := net.ListenUDP(network, nil)
+	if err != nil {
+		return err
+	}
+	defer connection.Close()
+
+	replies := make(chan udpReply, 64)
+	done := make(chan struct{})
+	defer close(done)
+	go readDatagrams(connection, replies, done, nil)
+	multicast := server.IP.IsMulticast()
+	if multicast {
+		group, err := joinGroup(server.IP.String(), server.Port, config.Interface)
+		if err != nil {
+			return fmt.Errorf("cannot join the group %s: %w", server.IP, err)
+		}
+		defer group.Close()
+		// The group hears our own datagrams as well, they are no replies.
+		go readDatagrams(group, replies, done, connection.LocalAddr().(*net.UDPAddr))
+		if err := setMulticastInterface(connection, server.IP, config.Interface); err != nil {
+			return err
+		}
+		fmt.Fprintf(output, "Joined the group %s, replies are collected for %v.\n", server.IP, config.Wait)
+	}
+
+	lines := bufio.NewReader(input)
+	fmt.Fprintln(output, "Type in the message you want to send to the server.")
+	for {
+		fmt.Fprint(output, ">> ")
+		text, err := lines.ReadString('\n')
+		if text == "" && err != nil {
+			return nil
+		}
+		sentAt := time.Now()
+		if _, err := connection.WriteTo([]byte(strings.TrimRight(text, "\r\n")), server); err != nil {
+			return err
+		}
+
+		// Waiting for the first reply, or for all of them from a group.
+		deadline := time.NewTimer(config.Wait)
+		received := 0
+	waiting:
+		for {
+			select {
+			case reply := <-replies:
+				if reply.at.Before(sentAt) {
+					// A late reply to an earlier datagram.
+					continue
+				}
+				received++
+				fmt.Fprintf(output, "-> %s: %s (%v)\n", reply.peer, describeMessage(reply.message), reply.at.Sub(sentAt).Round(time.Microsecond))
+				if !multicast {
+					break waiting
+				}
+			case <-deadline.C:
+				break waiting
 			}
-			log.Println("Error in receiving the message: ", err)
+		}
+		deadline.Stop()
+		if received == 0 {
+			fmt.Fprintf(output, "No reply within %v.\n", config.Wait)
+		} else if multicast {
+			fmt.Fprintf(output, "Replies: %d\n", received)
+		}
+	}
+}
+
+// This function passes the datagrams of the connection on until it is closed or the client is done,
+// skipping the ones sent from own.
+func readDatagrams(connection *net.UDPConn, replies chan<- udpReply, done <-chan struct{}, own *net.UDPAddr) {
+	var locals map[string]bool
+	if own != nil {
+		locals = localAddresses()
+	}
+	buffer := make([]byte, MAX_DATAGRAM_SIZE)
+	for {
+		size, peer, err := connection.ReadFromUDP(buffer)
+		if err != nil {
 			return
 		}
-		fmt.Println("<- ", string(msg))
+		if own != nil && peer.Port == own.Port && locals[peer.IP.String()] {
+			continue
+		}
+		select {
+		case replies <- udpReply{peer: peer, message: append([]byte(nil), buffer[:size]...), at: time.Now()}:
+		case <-done:
+			return
+		}
+	}
+}
+
+// This function lists the addresses of this machine.
+func localAddresses() map[string]bool {
+	locals := map[string]bool{}
+	addresses, _ := net.InterfaceAddrs()
+	for _, address := range addresses {
+		if network, ok := address.(*net.IPNet); ok {
+			locals[network.IP.String()] = true
+		}
+	}
+	return locals
+}
+
+// This function sends the datagrams to the group out of the named interface, the system picks one without a name.
+func setMulticastInterface(connection *net.UDPConn, group net.IP, interfaceName string) error {
+	if interfaceName == "" {
+		return nil
+	}
+	link, err := net.InterfaceByName(interfaceName)
+	if err != nil {
+		return err
+	}
+	if group.To4() != nil {
+		return ipv4.NewPacketConn(connection).SetMulticastInterface(link)
 	}
+	return ipv6.NewPacketConn(connection).SetMulticastInterface(link)
 }
@@ -0,0 +1,105 @@
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// This function starts a UDP server on a free loopback port and returns the client configuration reaching it.
+func startTestUDPServer(t *testing.T, config ServerConfig) ClientConfig {
+	t.Helper()
+	connection, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { connection.Close() })
+	go serveUDP(connection, config)
+	address := connection.LocalAddr().(*net.UDPAddr)
+	return ClientConfig{Host: "127.0.0.1", Port: address.Port, Wait: 2 * time.Second}
+}
+
+func TestUdpClientEcho(t *testing.T) {
+	config := startTestUDPServer(t, ServerConfig{Reply: "ECHO"})
+	var output bytes.Buffer
+	if err := udpClient(config, strings.NewReader("hello\r\nlast"), &output); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"127.0.0.1:", ": Echo: hello (", ": Echo: last ("} {
+		if !strings.Contains(output.String(), want) {
+			t.Errorf("got %q, want it to contain %q", output.String(), want)
+		}
+	}
+}
+
+func TestUdpServerCountsPerPeer(t *testing.T) {
+	rules := loadTestRules(t, "rules.yaml", "rules:\n  - exact: quiet\n  - reply: '{{.Message}} {{.Count}}'\n")
+	config := startTestUDPServer(t, ServerConfig{Reply: "ECHO", Rules: rules})
+	config.Wait = 100 * time.Millisecond
+	// Every client is a peer of its own, with its own count.
+	for client := 0; client < 2; client++ {
+		var output bytes.Buffer
+		if err := udpClient(config, strings.NewReader("a\nquiet\nb\n"), &output); err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{": a 1 (", "No reply within 100ms.", ": b 3 ("} {
+			if !strings.Contains(output.String(), want) {
+				t.Errorf("client %d: got %q, want it to contain %q", client+1, output.String(), want)
+			}
+		}
+	}
+}
+
+func TestUdpClientMulticast(t *testing.T) {
+	group, err := joinGroup("239.77.1.2", 0, "")
+	if err != nil {
+		t.Skipf("no multicast here: %v", err)
+	}
+	port := group.LocalAddr().(*net.UDPAddr).Port
+	// Two servers answer the group, the client waits for both.
+	second, err := joinGroup("239.77.1.2", port, "")
+	if err != nil {
+		t.Skipf("no second listener on the group: %v", err)
+	}
+	go serveUDP(group, ServerConfig{Reply: "ONE"})
+	go serveUDP(second, ServerConfig{Reply: "TWO"})
+	defer group.Close()
+	defer second.Close()
+
+	var output bytes.Buffer
+	config := ClientConfig{Host: "239.77.1.2", Port: port, Wait: 300 * time.Millisecond}
+	if err := udpClient(config, strings.NewReader("who is there\n"), &output); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(output.String(), "No reply") {
+		t.Skipf("the group is not routed here: %q", output.String())
+	}
+	for _, want := range []string{": ONE (", ": TWO (", "Replies: 2"} {
+		if !strings.Contains(output.String(), want) {
+			t.Errorf("got %q, want it to contain %q", output.String(), want)
+		}
+	}
+}
+
+func TestJoinGroupRejectsUnicast(t *testing.T) {
+	if _, err := joinGroup("192.0.2.1", 5000, ""); err == nil {
+		t.Error("a unicast address is no group")
+	}
+}
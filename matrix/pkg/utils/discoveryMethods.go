@@ -0,0 +1,142 @@
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The ways a host can be discovered.
+// icmp pings, tcp connects, syn sends half open SYNs, udp sends UDP probes and arp asks who-has on the local segment.
+var DiscoveryMethods = []string{"icmp", "tcp", "syn", "udp", "arp"}
+
+// The ports knocked on by the port based discovery methods when no ports are given.
+var DEFAULT_TCP_PING_PORTS = []int{22, 80, 443, 445, 3389}
+var DEFAULT_UDP_PING_PORTS = []int{53, 123, 137, 161}
+
+// The most hosts knocked on at once by the port based discovery methods, every port of a host is knocked on at the same time.
+const DISCOVERY_HOST_LIMIT = 128
+
+// This function makes sure that the user only asked for discovery methods that exist.
+func ValidateDiscoveryMethods(methods []string) error {
+	if len(methods) == 0 {
+		return fmt.Errorf("no discovery method given, choose from: %s", strings.Join(DiscoveryMethods, ", "))
+	}
+	for _, method := range methods {
+		known := false
+		for _, candidate := range DiscoveryMethods {
+			if method == candidate {
+				known = true
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown discovery method %q, choose from: %s", method, strings.Join(DiscoveryMethods, ", "))
+		}
+	}
+	return nil
+}
+
+/*
+Port based discovery.
+A host blocking pings still gives itself away when a port answers, and a refused connection is as good an answer as an accepted one.
+The port scanner does the knocking, only the question it answers changes.
+*/
+// This function knocks on the ports of a host at the same time and returns the first answer, or gives up after the ping timeout.
+// The syn method sends its probes through the scanner shared by all the hosts.
+func knockHost(ctx context.Context, method string, syn *synScanner, ip net.IP, ports []int, pingTimeout time.Duration) (pingResult, bool) {
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+
+	// The SYN and UDP probes are sent twice before giving up, both attempts have to fit in the time we have.
+	ceiling := pingTimeout
+	if method != "tcp" {
+		ceiling = pingTimeout / 2
+	}
+	tracker := newRttTracker(MIN_TIMEOUT, ceiling, false)
+	detector := serviceDetector{ctx: ctx, tracker: tracker, disabled: true}
+
+	// The channel holds every answer, so the knocks still running once we return never block.
+	replies := make(chan ScanResult, len(ports))
+	start := time.Now()
+	for _, port := range ports {
+		go func(port int) {
+			switch method {
+			case "syn":
+				scanSynPort(ctx, syn, ip, port, tracker, replies)
+			case "udp":
+				scanUDPPort(ctx, ip.String(), port, tracker, replies)
+			default:
+				scanPort(ctx, "tcp", ip.String(), port, tracker, detector, replies)
+			}
+		}(port)
+	}
+	for range ports {
+		reply := <-replies
+		if reply.State == "Open" || reply.State == "Closed" {
+			return pingResult{
+				ipAddress:    &net.IPAddr{IP: ip},
+				ipState:      "Up",
+				responseTime: time.Since(start),
+				method:       fmt.Sprintf("%s/%d", method, reply.Port),
+				reason:       reply.Reason,
+			}, true
+		}
+	}
+	return pingResult{}, false
+}
+
+// This function knocks on the ports of every address and passes every host that answers to the output formatter.
+// It works like pingSender, every host gets the ping timeout to answer and it returns once all of them are through.
+// The SYN scanner is opened once for all the hosts, a scan that cannot open it fails instead of finding nothing.
+func portPingSender(ctx context.Context, method string, ipsToScan []net.IP, ports []int, pingResultChannel chan<- pingResult, pingTimeout time.Duration) error {
+	var syn *synScanner
+	if method == "syn" {
+		var err error
+		if syn, err = newSynScanner(); err != nil {
+			return err
+		}
+		defer syn.close()
+	}
+	hostlimitChannel := make(chan struct{}, DISCOVERY_HOST_LIMIT)
+	wg := sync.WaitGroup{}
+
+knockLoop:
+	for _, ip := range ipsToScan {
+		select {
+		case hostlimitChannel <- struct{}{}:
+		case <-ctx.Done():
+			break knockLoop
+		}
+		wg.Add(1)
+		go func(ip net.IP) {
+			defer wg.Done()
+			defer func() { <-hostlimitChannel }()
+			if result, found := knockHost(ctx, method, syn, ip, ports, pingTimeout); found {
+				select {
+				case pingResultChannel <- result:
+				case <-ctx.Done():
+				}
+			}
+		}(ip)
+	}
+	wg.Wait()
+	return ctx.Err()
+}
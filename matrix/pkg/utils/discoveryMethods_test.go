@@ -0,0 +1,65 @@
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestKnockHost(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	openPort := listener.Addr().(*net.TCPAddr).Port
+
+	// Closing a listener frees a port nobody is listening on.
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+
+	loopback := net.ParseIP("127.0.0.1")
+	result, found := knockHost(context.Background(), "tcp", nil, loopback, []int{openPort}, time.Second)
+	if !found || result.method != fmt.Sprintf("tcp/%d", openPort) || result.reason != "syn-ack" {
+		t.Errorf("knocking on an open port = %+v, %v, want it found through tcp/%d with a syn-ack", result, found, openPort)
+	}
+
+	// A refused connection still proves that the host is there.
+	result, found = knockHost(context.Background(), "tcp", nil, loopback, []int{closedPort}, time.Second)
+	if !found || result.reason != "conn-refused" {
+		t.Errorf("knocking on a closed port = %+v, %v, want it found with conn-refused", result, found)
+	}
+}
+
+func TestValidateDiscoveryMethods(t *testing.T) {
+	if err := ValidateDiscoveryMethods([]string{"icmp", "tcp", "arp"}); err != nil {
+		t.Errorf("known methods rejected: %v", err)
+	}
+	if err := ValidateDiscoveryMethods([]string{"icmp", "carrier-pigeon"}); err == nil {
+		t.Error("an unknown method was accepted")
+	}
+	if err := ValidateDiscoveryMethods(nil); err == nil {
+		t.Error("an empty method list was accepted")
+	}
+}
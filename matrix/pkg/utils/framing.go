@@ -0,0 +1,168 @@
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The ways the TCP server tells the messages of a stream apart.
+var Framings = []string{"newline", "length2", "length4", "fixed", "raw"}
+
+// The largest message a length prefix may announce, a corrupt prefix should not make us allocate gigabytes.
+const MAX_FRAME_SIZE = 16 * 1024 * 1024
+
+// How much a raw stream is read at a time, every read is a message of its own.
+const RAW_READ_SIZE = 64 * 1024
+
+/*
+Message framing.
+A TCP stream carries bytes, not messages, every protocol has its own way of marking where a message ends.
+Newline framing is the line chat the server always spoke, the length prefixes (big-endian, 2 or 4 bytes) and
+fixed size frames cover most binary protocols, and raw takes every read as it comes.
+*/
+type messageFramer struct {
+	framing string
+	size    int
+	reader  *bufio.Reader
+}
+
+// This function checks the framing and its frame size before the server starts.
+func ValidateFraming(framing string, size int) error {
+	known := false
+	for _, name := range Framings {
+		known = known || framing == name
+	}
+	if !known {
+		return fmt.Errorf("unknown framing %q, choose one of: %s", framing, strings.Join(Framings, ", "))
+	}
+	if framing == "fixed" && (size <= 0 || size > MAX_FRAME_SIZE) {
+		return fmt.Errorf("fixed framing needs a frame size between 1 and %d bytes", MAX_FRAME_SIZE)
+	}
+	return nil
+}
+
+// This function reads the messages of the stream with the given framing.
+// The reader lives as long as the connection, the bytes it buffered belong to the next message.
+func newMessageFramer(stream io.Reader, framing string, size int) *messageFramer {
+	return &messageFramer{framing: framing, size: size, reader: bufio.NewReader(stream)}
+}
+
+// This function returns the next message without its framing.
+// It returns io.EOF once the peer closed the stream between two messages and io.ErrUnexpectedEOF when it did so in the middle of one.
+func (framer *messageFramer) read() ([]byte, error) {
+	switch framer.framing {
+	case "length2", "length4":
+		header := make([]byte, 2)
+		if framer.framing == "length4" {
+			header = make([]byte, 4)
+		}
+		if _, err := io.ReadFull(framer.reader, header); err != nil {
+			return nil, err
+		}
+		var length uint32
+		if len(header) == 2 {
+			length = uint32(binary.BigEndian.Uint16(header))
+		} else {
+			length = binary.BigEndian.Uint32(header)
+		}
+		if length > MAX_FRAME_SIZE {
+			return nil, fmt.Errorf("the peer announced a message of %d bytes, more than the %d we accept", length, MAX_FRAME_SIZE)
+		}
+		message := make([]byte, length)
+		if _, err := io.ReadFull(framer.reader, message); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		return message, nil
+
+	case "fixed":
+		message := make([]byte, framer.size)
+		if _, err := io.ReadFull(framer.reader, message); err != nil {
+			return nil, err
+		}
+		return message, nil
+
+	case "raw":
+		buffer := make([]byte, RAW_READ_SIZE)
+		n, err := framer.reader.Read(buffer)
+		if n > 0 {
+			return buffer[:n], nil
+		}
+		return nil, err
+	}
+
+	// The last line of a stream may come without its newline, it is a message all the same.
+	line, err := framer.reader.ReadBytes('\n')
+	if len(line) == 0 {
+		return nil, err
+	}
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	return bytes.TrimSuffix(line, []byte("\r")), nil
+}
+
+// This function wraps a message in the framing, so the peer reads it the way it writes its own.
+// Fixed frames are padded with zero bytes, a longer message takes several frames.
+func (framer *messageFramer) frame(message []byte) ([]byte, error) {
+	switch framer.framing {
+	case "length2":
+		if len(message) > 0xffff {
+			return nil, fmt.Errorf("a message of %d bytes does not fit a 2 byte length prefix", len(message))
+		}
+		return append(binary.BigEndian.AppendUint16(nil, uint16(len(message))), message...), nil
+	case "length4":
+		return append(binary.BigEndian.AppendUint32(nil, uint32(len(message))), message...), nil
+	case "fixed":
+		frames := (len(message) + framer.size - 1) / framer.size
+		if frames == 0 {
+			frames = 1
+		}
+		framed := make([]byte, frames*framer.size)
+		copy(framed, message)
+		return framed, nil
+	case "raw":
+		return message, nil
+	}
+	return append(message, '\n'), nil
+}
+
+// This function turns the end of the stream in the middle of a message into an error of its own.
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// This function shows a message on the console, as text when it is text and in hex when it is not.
+func describeMessage(message []byte) string {
+	printable := utf8.Valid(message)
+	for _, character := range string(message) {
+		printable = printable && (unicode.IsPrint(character) || character == '\t')
+	}
+	if printable {
+		return string(message)
+	}
+	return fmt.Sprintf("%d bytes: %s", len(message), hex.EncodeToString(message))
+}
@@ -0,0 +1,169 @@
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMessageFramerRead(t *testing.T) {
+	tests := []struct {
+		name     string
+		framing  string
+		size     int
+		stream   []byte
+		messages []string
+		err      error
+	}{
+		{"lines", "newline", 0, []byte("hello\r\nworld\n"), []string{"hello", "world"}, io.EOF},
+		{"last line without newline", "newline", 0, []byte("hello\nbye"), []string{"hello", "bye"}, io.EOF},
+		{"two byte prefix", "length2", 0, []byte("\x00\x02hi\x00\x00\x00\x03abc"), []string{"hi", "", "abc"}, io.EOF},
+		{"four byte prefix", "length4", 0, []byte("\x00\x00\x00\x05\x01\x02\x03\x04\x05"), []string{"\x01\x02\x03\x04\x05"}, io.EOF},
+		{"cut off message", "length2", 0, []byte("\x00\x05abc"), nil, io.ErrUnexpectedEOF},
+		{"fixed frames", "fixed", 3, []byte("abcdef"), []string{"abc", "def"}, io.EOF},
+		{"cut off frame", "fixed", 4, []byte("abcdef"), []string{"abcd"}, io.ErrUnexpectedEOF},
+	}
+	for _, test := range tests {
+		framer := newMessageFramer(bytes.NewReader(test.stream), test.framing, test.size)
+		var messages []string
+		var err error
+		for {
+			var message []byte
+			if message, err = framer.read(); err != nil {
+				break
+			}
+			messages = append(messages, string(message))
+		}
+		if !reflect.DeepEqual(messages, test.messages) || err != test.err {
+			t.Errorf("%s: read %q ending with %v, want %q ending with %v", test.name, messages, err, test.messages, test.err)
+		}
+	}
+}
+
+func TestMessageFramerRejectsHugeFrames(t *testing.T) {
+	framer := newMessageFramer(bytes.NewReader([]byte("\xff\xff\xff\xff")), "length4", 0)
+	if _, err := framer.read(); err == nil || err == io.EOF {
+		t.Errorf("a 4 GB message was accepted: %v", err)
+	}
+}
+
+func TestMessageFramerFrame(t *testing.T) {
+	tests := []struct {
+		framing string
+		size    int
+		message string
+		want    string
+	}{
+		{"newline", 0, "hi", "hi\n"},
+		{"length2", 0, "hi", "\x00\x02hi"},
+		{"length4", 0, "hi", "\x00\x00\x00\x02hi"},
+		{"fixed", 4, "hi", "hi\x00\x00"},
+		{"fixed", 2, "abc", "abc\x00"},
+		{"raw", 0, "hi", "hi"},
+	}
+	for _, test := range tests {
+		framer := newMessageFramer(nil, test.framing, test.size)
+		if got, err := framer.frame([]byte(test.message)); err != nil || string(got) != test.want {
+			t.Errorf("%s: frame(%q) = %q, %v, want %q", test.framing, test.message, got, err, test.want)
+		}
+	}
+	if _, err := newMessageFramer(nil, "length2", 0).frame(make([]byte, 70000)); err == nil {
+		t.Error("a message too long for its 2 byte prefix was framed")
+	}
+}
+
+func TestValidateFraming(t *testing.T) {
+	tests := []struct {
+		framing string
+		size    int
+		valid   bool
+	}{
+		{"newline", 0, true},
+		{"length4", 0, true},
+		{"fixed", 16, true},
+		{"fixed", 0, false},
+		{"xml", 0, false},
+	}
+	for _, test := range tests {
+		if err := ValidateFraming(test.framing, test.size); (err == nil) != test.valid {
+			t.Errorf("ValidateFraming(%q, %d) = %v", test.framing, test.size, err)
+		}
+	}
+}
+
+// This function starts a TCP server on a free loopback port and returns its address.
+func startTestServer(t *testing.T, config ServerConfig) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go serveTCP(listener, config)
+	return listener.Addr().String()
+}
+
+func TestServeTCPKeepsBufferedLines(t *testing.T) {
+	address := startTestServer(t, ServerConfig{Reply: "ECHO", Framing: "newline"})
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	// Both lines arrive in one segment, the second one used to be dropped with the reader of the first.
+	conn.Write([]byte("one\ntwo\n"))
+	reader := bufio.NewReader(conn)
+	for _, want := range []string{"Echo: one\n", "Echo: two\n"} {
+		if line, err := reader.ReadString('\n'); err != nil || line != want {
+			t.Errorf("got %q, %v, want %q", line, err, want)
+		}
+	}
+}
+
+func TestServeTCPLengthPrefixed(t *testing.T) {
+	address := startTestServer(t, ServerConfig{Reply: "ECHO", Framing: "length2"})
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	// The frame is split over two writes, the server has to wait for the rest of it.
+	conn.Write([]byte("\x00\x04\x00\xff"))
+	time.Sleep(20 * time.Millisecond)
+	conn.Write([]byte("\x01\x02"))
+	reply := make([]byte, 6)
+	if _, err := io.ReadFull(conn, reply); err != nil || string(reply) != "\x00\x04\x00\xff\x01\x02" {
+		t.Errorf("got %q, %v, want the binary message echoed back untouched", reply, err)
+	}
+}
+
+func TestDescribeMessage(t *testing.T) {
+	if got := describeMessage([]byte("hello world")); got != "hello world" {
+		t.Errorf("describeMessage() of text = %q", got)
+	}
+	if got := describeMessage([]byte{0x00, 0xff}); !strings.Contains(got, "00ff") {
+		t.Errorf("describeMessage() of binary = %q, want it in hex", got)
+	}
+}
@@ -16,160 +16,472 @@ limitations under the License.
 package utils
 
 import (
-	"encoding/binary"
+	"context"
 	"fmt"
-	"log"
 	"net"
-	"os"
+	"net/netip"
+	"sync"
 	"time"
-
-	"github.com/schollz/progressbar"
-	"github.com/tatsushid/go-fastping"
 )
 
-type ipData struct {
-	Ipaddress    string
-	State        string
-	Hostname     []string
-	ResponseTime time.Duration
+type IpData struct {
+	Ipaddress    string        `json:"ip_address"`
+	State        string        `json:"state"`
+	Hostname     []string      `json:"hostnames,omitempty"`
+	ResponseTime time.Duration `json:"response_time_ns"`
+	// Only known for hosts that answered an ARP request.
+	MAC    string `json:"mac,omitempty"`
+	Vendor string `json:"vendor,omitempty"`
+	// The discovery method the host answered first, such as icmp, arp or tcp/443, and what the answer was.
+	Method string `json:"method,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	// Only known for hosts pinged more than once, the response time is then the average.
+	Stats *PingStats `json:"stats,omitempty"`
+}
+
+// The settings of a host discovery scan.
+type HostScanConfig struct {
+	// How long to wait for a host to answer, the scan is over once every host has answered or run out of time.
+	PingTimeout time.Duration
+	// How many more times the icmp and arp methods ask a host that did not answer.
+	Retries int
+	// The interface used for ARP and for the all-nodes group of large IPv6 networks, found from the network when empty.
+	Interface string
+	// The ways the hosts are looked for, from DiscoveryMethods. A host is up as soon as one of them gets an answer.
+	Methods []string
+	// The ports knocked on by the tcp and syn methods and by the udp method, the defaults when empty.
+	TCPPorts []int
+	UDPPorts []int
+	// How many times the icmp method pings every host, once when zero.
+	Count int
+	// Also report the hosts that did not answer at all, as Down.
+	ReportDown bool
+	// How the names of the hosts that answered are looked up.
+	Names ResolverConfig
 }
 
 type pingResult struct {
 	ipAddress    *net.IPAddr
 	ipState      string
 	responseTime time.Duration
+	mac          net.HardwareAddr
+	method       string
+	reason       string
+	stats        *PingStats
 }
 
-// This function takes a network CIDR and processes it.
-// The output is a list of IPs contained within the network CIDR.
-func convertToIPs(networkCidr string) []net.IP {
-	// convert string to IPNet struct
-	_, ipv4Net, err := net.ParseCIDR(networkCidr)
-	if err != nil {
-		log.Fatal(err)
-	}
+// A discovery worker probes the hosts one way and passes the answers on, it returns once it is done.
+type discoveryWorker func(ctx context.Context, answers chan<- pingResult) error
 
-	// convert IPNet struct mask and address to uint32
-	mask := binary.BigEndian.Uint32(ipv4Net.Mask)
-	start := binary.BigEndian.Uint32(ipv4Net.IP)
+// A host pinged by pingSender, with the times of the pings sent to it and of the replies it gave.
+type pingTarget struct {
+	ip     net.IP
+	sentAt []time.Time
+	rtts   map[int]time.Duration
+	// When the host is due for its next ping, or out of time once every ping is sent.
+	nextAt time.Time
+	done   bool
+}
 
-	// find the final address
-	finish := (start & mask) | (mask ^ 0xffffffff)
+// The hosts are pinged this many at a time, one batch every interval, so a /16 does not flood the network in one go.
+const (
+	PING_BATCH_SIZE     = 256
+	PING_BATCH_INTERVAL = 100 * time.Millisecond
+)
+
+// How often the hosts waiting for a reply are checked for their next ping or their timeout.
+const PING_TICK = 20 * time.Millisecond
+
+// A network is pinged one address at a time only when it has at most this many host bits, a /16 or a /112.
+const HOST_BITS_LIMIT = 16
 
-	// loop through addresses as uint32 and store them in a slice.
+// This function takes a network CIDR and returns the list of IPs contained within it.
+func expandCIDR(networkCidr string) ([]net.IP, error) {
+	prefix, err := netip.ParsePrefix(networkCidr)
+	if err != nil {
+		return nil, err
+	}
+	prefix = prefix.Masked()
+	hostBits := prefix.Addr().BitLen() - prefix.Bits()
+	if hostBits > HOST_BITS_LIMIT {
+		if prefix.Addr().Is4() {
+			return nil, fmt.Errorf("invalid network %q: networks larger than a /16 are not supported", networkCidr)
+		}
+		return nil, fmt.Errorf("invalid network %q: it holds 2^%d addresses, IPv6 networks larger than a /112 cannot be scanned address by address", networkCidr, hostBits)
+	}
+
+	// Walk the addresses until we step out of the network.
+	// Next returns an invalid address after the very last one, so a network at the top of the address space ends as well.
 	ipStore := []net.IP{}
-	for i := start; i <= finish; i++ {
-		// convert back to net.IP
-		ip := make(net.IP, 4)
-		binary.BigEndian.PutUint32(ip, i)
-		ipStore = append(ipStore, ip)
+	for address := prefix.Addr(); prefix.Contains(address); address = address.Next() {
+		ipStore = append(ipStore, net.IP(address.AsSlice()))
 	}
-	return ipStore
+	return ipStore, nil
 }
 
-// This function is responsible for sending ping packets to all the machines within the network.
-// Once we start receiving replies we send them to the output formatter for further processing.
-func pingSender(ipsToScan []net.IP, pingResultChannel chan pingResult, finishChannel chan string, pingTimer int) {
-	// Setup the IP pinger.
-	p := fastping.NewPinger()
-	p.MaxRTT = time.Second*time.Duration(pingTimer) + 1
-	var pingOutput pingResult
-
-	for _, ip := range ipsToScan {
-		// Resolve the ip addresses and add them to a IP address pinger.
-		resolvedAddress, err := net.ResolveIPAddr("ip4:icmp", ip.String())
-		if err != nil {
-			os.Exit(1)
+// This function decides how a network is discovered.
+// Small networks are pinged address by address, large IPv6 networks of a local link through the all-nodes group of the link.
+func planDiscovery(networkCidr string, interfaceName string) ([]net.IP, *net.Interface, error) {
+	ips, err := expandCIDR(networkCidr)
+	if err == nil {
+		return ips, nil, nil
+	}
+	prefix, parseErr := netip.ParsePrefix(networkCidr)
+	if parseErr != nil || !prefix.Addr().Is6() {
+		return nil, nil, err
+	}
+	link, linkErr := linkFor(prefix.Masked(), interfaceName)
+	if linkErr != nil {
+		return nil, nil, fmt.Errorf("%v, and it cannot be pinged through the all-nodes group either: %v", err, linkErr)
+	}
+	return nil, link, nil
+}
+
+// This function returns the number of addresses pinged one by one in a network.
+// Networks discovered through the all-nodes group of their link have no such number and count as zero.
+func NetworkSize(networkCidr string, interfaceName string) (int, error) {
+	ips, _, err := planDiscovery(networkCidr, interfaceName)
+	return len(ips), err
+}
+
+// This function returns the longest a discovery of the given number of addresses can take, most scans are over much sooner.
+// Networks discovered through the all-nodes group listen for the ping timeout.
+func MaxDiscoveryTime(addresses int, config HostScanConfig) time.Duration {
+	count := config.Count
+	if count <= 0 {
+		count = 1
+	}
+	methods := config.Methods
+	if len(methods) == 0 {
+		methods = []string{"icmp"}
+	}
+	if addresses == 0 {
+		return config.PingTimeout
+	}
+	var longest time.Duration
+	for _, method := range methods {
+		var duration time.Duration
+		switch method {
+		case "icmp":
+			batches := (addresses + PING_BATCH_SIZE - 1) / PING_BATCH_SIZE
+			duration = time.Duration(batches-1) * PING_BATCH_INTERVAL
+			if count > 1 {
+				duration += time.Duration(count-1)*PING_INTERVAL + config.PingTimeout
+			} else {
+				duration += time.Duration(config.Retries+1) * config.PingTimeout
+			}
+		case "arp":
+			duration = time.Duration(config.Retries+1) * config.PingTimeout
+		default:
+			rounds := (addresses + DISCOVERY_HOST_LIMIT - 1) / DISCOVERY_HOST_LIMIT
+			duration = time.Duration(rounds) * config.PingTimeout
+		}
+		if duration > longest {
+			longest = duration
 		}
-		p.AddIPAddr(resolvedAddress)
 	}
+	return longest
+}
 
-	p.OnRecv = func(addr *net.IPAddr, rtt time.Duration) {
-		pingOutput.ipAddress = addr
-		pingOutput.ipState = "Up"
-		pingOutput.responseTime = rtt
-		pingResultChannel <- pingOutput
+// This function tells which address families are among the addresses, the ICMP sockets are opened for those.
+func addressFamilies(ips []net.IP) (withIPv4 bool, withIPv6 bool) {
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			withIPv4 = true
+		} else {
+			withIPv6 = true
+		}
 	}
+	return withIPv4, withIPv6
+}
 
-	// Once the final time passes stop the program.
-	p.OnIdle = func() {
-		finishChannel <- "Completed"
+// This function is responsible for sending ping packets to all the machines within the network.
+// Once we start receiving replies we send them to the output formatter for further processing.
+// A host pinged once is passed on as soon as it answers, a host pinged count times only once its last round is over.
+// The scan is complete as soon as every host has answered or run out of time, nobody waits for the whole network.
+func pingSender(ctx context.Context, pinger echoSocket, ipsToScan []net.IP, count int, retries int, pingResultChannel chan<- pingResult, pingTimeout time.Duration) error {
+	replies := make(chan echoReply)
+	pinger.receive(replies)
+
+	targets := make([]pingTarget, len(ipsToScan))
+	lookup := make(map[string]*pingTarget, len(ipsToScan))
+	for index, ip := range ipsToScan {
+		targets[index] = pingTarget{ip: ip, rtts: map[int]time.Duration{}}
+		lookup[ip.String()] = &targets[index]
 	}
-	err := p.Run()
-	if err != nil {
-		panic(err)
+	// Every ping goes out with its own sequence number, the round or the retry it belongs to.
+	send := func(target *pingTarget, now time.Time) error {
+		target.sentAt = append(target.sentAt, now)
+		if len(target.sentAt) < count {
+			target.nextAt = now.Add(PING_INTERVAL)
+		} else {
+			target.nextAt = now.Add(pingTimeout)
+		}
+		return pinger.send(&net.IPAddr{IP: target.ip}, len(target.sentAt)-1)
+	}
+	report := func(target *pingTarget) error {
+		pingOutput := pingResult{ipAddress: &net.IPAddr{IP: target.ip}, ipState: "Up", method: "icmp", reason: "echo-reply"}
+		if count > 1 {
+			var hostRtts []time.Duration
+			for _, rtt := range target.rtts {
+				hostRtts = append(hostRtts, rtt)
+			}
+			pingOutput.stats = newPingStats(count, hostRtts)
+			pingOutput.responseTime = pingOutput.stats.Avg
+		} else {
+			for _, rtt := range target.rtts {
+				pingOutput.responseTime = rtt
+			}
+		}
+		select {
+		case pingResultChannel <- pingOutput:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
-}
 
-// This function receives the results from the ping Sender and formats them with more information to make it presentable.
-func outputFormatter(pingResultChannel chan pingResult, ipDataResults chan ipData, ipsToScan []net.IP, finishChannel chan string) {
+	// The hosts are pinged a batch at a time, the hosts in flight are checked on every tick.
+	active := map[*pingTarget]struct{}{}
+	next, pending := 0, len(targets)
+	var nextBatch time.Time
+	ticker := time.NewTicker(PING_TICK)
+	defer ticker.Stop()
+	for pending > 0 {
+		now := time.Now()
+		if next < len(targets) && !now.Before(nextBatch) {
+			batchEnd := next + PING_BATCH_SIZE
+			if batchEnd > len(targets) {
+				batchEnd = len(targets)
+			}
+			for ; next < batchEnd; next++ {
+				if err := send(&targets[next], now); err != nil {
+					return err
+				}
+				active[&targets[next]] = struct{}{}
+			}
+			nextBatch = now.Add(PING_BATCH_INTERVAL)
+		}
 
-	// Wait for each ping result and perform a IP lookup.
-	for {
 		select {
-		case finishMessage := <-finishChannel:
-			if finishMessage == "Completed" {
-				finishChannel <- "Finish"
+		case reply := <-replies:
+			target, found := lookup[reply.address.IP.String()]
+			// Replies to pings we never sent, duplicated replies and late ones are ignored.
+			if !found || target.done || reply.seq >= len(target.sentAt) {
+				continue
+			}
+			if _, duplicate := target.rtts[reply.seq]; duplicate {
+				continue
+			}
+			target.rtts[reply.seq] = reply.at.Sub(target.sentAt[reply.seq])
+			// A single ping is answered by any of its retries, a count of pings only by all of them.
+			if count > 1 && len(target.rtts) < count {
+				continue
+			}
+			target.done = true
+			delete(active, target)
+			pending--
+			if err := report(target); err != nil {
+				return err
 			}
 
-		case pingOutput := <-pingResultChannel:
-			var ipDetails ipData
+		case now := <-ticker.C:
+			for target := range active {
+				if now.Before(target.nextAt) {
+					continue
+				}
+				var err error
+				switch {
+				case count > 1 && len(target.sentAt) < count:
+					err = send(target, now)
+				case count == 1 && len(target.sentAt) <= retries:
+					err = send(target, now)
+				default:
+					// The host is out of time, whatever it answered is all we get.
+					target.done = true
+					delete(active, target)
+					pending--
+					if len(target.rtts) > 0 {
+						err = report(target)
+					}
+				}
+				if err != nil {
+					return err
+				}
+			}
 
-			// Capture the IP data.
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
 
-			ipDetails.Ipaddress = pingOutput.ipAddress.String()
-			ipDetails.State = pingOutput.ipState
-			ipDetails.ResponseTime = pingOutput.responseTime
+	return nil
+}
 
-			// Perform a Name Lookup.
-			lookup, err := net.LookupAddr(pingOutput.ipAddress.String())
-			if err == nil {
-				ipDetails.Hostname = lookup
-			} else {
-				ipDetails.Hostname = []string{"N/A"}
+// This function collects the answers of the discovery workers and turns them into the hosts we report.
+// A host answering several times, or to several methods, is reported once for the answer that came first.
+// The names of the hosts are looked up by the resolver while the scan goes on, so a slow name server does not hold it up.
+// The returned channel is closed once the answers are closed and every host is out, with reportDown after the addresses that never answered.
+func collectHosts(ctx context.Context, answers <-chan pingResult, ipsToScan []net.IP, reportDown bool, resolver *Resolver) <-chan IpData {
+	hosts := make(chan IpData)
+	lookupGroup := sync.WaitGroup{}
+	go func() {
+		defer close(hosts)
+		seen := map[string]bool{}
+		for answer := range answers {
+			address := answer.ipAddress.String()
+			if seen[address] {
+				continue
+			}
+			seen[address] = true
+			host := IpData{
+				Ipaddress:    address,
+				State:        answer.ipState,
+				ResponseTime: answer.responseTime,
+				Method:       answer.method,
+				Reason:       answer.reason,
+				Stats:        answer.stats,
+			}
+			if answer.mac != nil {
+				host.MAC = answer.mac.String()
+				host.Vendor = MacVendor(answer.mac)
 			}
-			ipDataResults <- ipDetails
+			lookupGroup.Add(1)
+			go func(host IpData) {
+				defer lookupGroup.Done()
+				// Perform a Name Lookup.
+				host.Hostname = resolver.Lookup(ctx, host.Ipaddress)
+				hosts <- host
+			}(host)
 		}
-	}
+		lookupGroup.Wait()
+
+		if !reportDown || ctx.Err() != nil {
+			return
+		}
+		for _, ip := range ipsToScan {
+			// Nobody answers a name lookup for a host that is not there, so none is made.
+			if !seen[ip.String()] {
+				hosts <- IpData{Ipaddress: ip.String(), State: "Down", Reason: "no-response"}
+			}
+		}
+	}()
+	return hosts
 }
 
-// A function to show a simple progress bar.
-func progressBar(pingTimer int) {
-	bar := progressbar.New(pingTimer)
-	for i := 1; i <= pingTimer; i++ {
-		bar.Add(1)
-		time.Sleep(1 * time.Second)
+// This function runs the discovery workers at the same time and hands every host found to onResult as it comes in.
+// The first worker to fail stops the others, its error is returned along with the hosts found until then.
+func runDiscovery(ctx context.Context, workers []discoveryWorker, ipsToScan []net.IP, reportDown bool, resolver *Resolver, onResult func(IpData)) ([]IpData, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	answers := make(chan pingResult)
+	workerGroup := sync.WaitGroup{}
+	var failure error
+	var failOnce sync.Once
+	for _, worker := range workers {
+		workerGroup.Add(1)
+		go func(worker discoveryWorker) {
+			defer workerGroup.Done()
+			if err := worker(ctx, answers); err != nil {
+				failOnce.Do(func() {
+					failure = err
+					cancel()
+				})
+			}
+		}(worker)
+	}
+	// The answers are closed once every worker is through, which in turn lets the collector finish.
+	go func() {
+		workerGroup.Wait()
+		close(answers)
+	}()
+
+	var scanResults []IpData
+	for host := range collectHosts(ctx, answers, ipsToScan, reportDown, resolver) {
+		if onResult != nil {
+			onResult(host)
+		}
+		scanResults = append(scanResults, host)
 	}
-	fmt.Print("\n")
+	return scanResults, failure
 }
 
-// This function is the control function which controls how the hosts are discovered within the network.
-func DiscoverHosts(networkCidr string, pingTimer int) []ipData {
-	// Setting up the variables and the channels for communication between the threads.
-	ipsToScan := convertToIPs(networkCidr)
-	pingResultChannel := make(chan pingResult)
-	ipDataResults := make(chan ipData)
-	finishChannel := make(chan string)
-	var scanResults []ipData
-
-	// Display welcome message and progress bar.
-	fmt.Printf("This scan will run for %d Seconds to find LAN peers.\n", pingTimer)
-	go progressBar(pingTimer)
-
-	// Start a ping sender and reply receiver.
-	go pingSender(ipsToScan, pingResultChannel, finishChannel, pingTimer)
-	go outputFormatter(pingResultChannel, ipDataResults, ipsToScan, finishChannel)
-
-	// Capture all the replies as the outputFormatter sends them.
-	// Close when the formatter says it is done.
-	for {
-		select {
-		case ipResults := <-ipDataResults:
-			scanResults = append(scanResults, ipResults)
-		case finishMessage := <-finishChannel:
-			if finishMessage == "Finish" {
-				return scanResults
-			}
+/*
+Main discovery function.
+This function is the control function which controls how the hosts are discovered within the network.
+Every host is handed to onResult (when given) as soon as it answers.
+A cancelled scan returns the hosts found so far along with the error of the context.
+*/
+func DiscoverHosts(ctx context.Context, networkCidr string, config HostScanConfig, onResult func(IpData)) ([]IpData, error) {
+	ipsToScan, link, err := planDiscovery(networkCidr, config.Interface)
+	if err != nil {
+		return nil, err
+	}
+	tcpPorts, udpPorts := config.TCPPorts, config.UDPPorts
+	if len(tcpPorts) == 0 {
+		tcpPorts = DEFAULT_TCP_PING_PORTS
+	}
+	if len(udpPorts) == 0 {
+		udpPorts = DEFAULT_UDP_PING_PORTS
+	}
+	count := config.Count
+	if count <= 0 {
+		count = 1
+	}
+	if count > PING_COUNT_LIMIT {
+		return nil, fmt.Errorf("every host can be pinged at most %d times", PING_COUNT_LIMIT)
+	}
+	methods := config.Methods
+	if len(methods) == 0 {
+		methods = []string{"icmp"}
+	}
+	if err := ValidateDiscoveryMethods(methods); err != nil {
+		return nil, err
+	}
+	resolver, err := NewResolver(config.Names)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every method gets its own worker, they all report to the same collector.
+	var workers []discoveryWorker
+	for _, method := range methods {
+		method := method
+		switch {
+		case link != nil && method != "icmp":
+			return nil, fmt.Errorf("networks larger than a /112 are found through the all-nodes group, which only the icmp method can ping")
+		case link != nil:
+			workers = append(workers, func(ctx context.Context, answers chan<- pingResult) error {
+				pinger, err := newEchoPinger(false, true)
+				if err != nil {
+					return err
+				}
+				defer pinger.close()
+				return allNodesSender(ctx, pinger, link, answers, config.PingTimeout)
+			})
+		case method == "icmp":
+			workers = append(workers, func(ctx context.Context, answers chan<- pingResult) error {
+				pinger, err := newEchoPinger(addressFamilies(ipsToScan))
+				if err != nil {
+					return err
+				}
+				defer pinger.close()
+				return pingSender(ctx, pinger, ipsToScan, count, config.Retries, answers, config.PingTimeout)
+			})
+		case method == "arp":
+			workers = append(workers, func(ctx context.Context, answers chan<- pingResult) error {
+				return arpSender(ctx, ipsToScan, config.Interface, config.Retries, answers, config.PingTimeout)
+			})
+		case method == "udp":
+			workers = append(workers, func(ctx context.Context, answers chan<- pingResult) error {
+				return portPingSender(ctx, method, ipsToScan, udpPorts, answers, config.PingTimeout)
+			})
+		default:
+			workers = append(workers, func(ctx context.Context, answers chan<- pingResult) error {
+				return portPingSender(ctx, method, ipsToScan, tcpPorts, answers, config.PingTimeout)
+			})
 		}
 	}
+	return runDiscovery(ctx, workers, ipsToScan, config.ReportDown, resolver, onResult)
 }
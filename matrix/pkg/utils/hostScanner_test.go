@@ -0,0 +1,253 @@
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A fake network behind the echoSocket, every address answers as often as it is told to.
+type fakeEchoNetwork struct {
+	lock sync.Mutex
+	// How many replies every ping to an address gets, the addresses missing from it never answer.
+	answers map[string]int
+	// How many pings to an address get lost before it starts answering.
+	lost    map[string]int
+	sent    map[string]int
+	replies chan<- echoReply
+	closed  chan struct{}
+}
+
+func newFakeEchoNetwork(answers map[string]int, lost map[string]int) *fakeEchoNetwork {
+	return &fakeEchoNetwork{answers: answers, lost: lost, sent: map[string]int{}, closed: make(chan struct{})}
+}
+
+func (network *fakeEchoNetwork) send(address *net.IPAddr, seq int) error {
+	network.lock.Lock()
+	defer network.lock.Unlock()
+	network.sent[address.IP.String()]++
+	if network.sent[address.IP.String()] <= network.lost[address.IP.String()] {
+		return nil
+	}
+	for copies := network.answers[address.IP.String()]; copies > 0; copies-- {
+		go func(replies chan<- echoReply) {
+			select {
+			case replies <- echoReply{address: address, seq: seq, at: time.Now().Add(time.Millisecond)}:
+			case <-network.closed:
+			}
+		}(network.replies)
+	}
+	return nil
+}
+
+func (network *fakeEchoNetwork) receive(replies chan<- echoReply) {
+	network.lock.Lock()
+	defer network.lock.Unlock()
+	network.replies = replies
+}
+
+func (network *fakeEchoNetwork) close() {
+	close(network.closed)
+}
+
+func (network *fakeEchoNetwork) pings(address string) int {
+	network.lock.Lock()
+	defer network.lock.Unlock()
+	return network.sent[address]
+}
+
+// This function pings the addresses through the fake network and returns the answers by address.
+func fakePingSender(t *testing.T, network *fakeEchoNetwork, addresses []string, count int, retries int, timeout time.Duration) map[string][]pingResult {
+	t.Helper()
+	var ips []net.IP
+	for _, address := range addresses {
+		ips = append(ips, net.ParseIP(address))
+	}
+	answers := make(chan pingResult)
+	results := map[string][]pingResult{}
+	done := make(chan error)
+	go func() {
+		done <- pingSender(context.Background(), network, ips, count, retries, answers, timeout)
+	}()
+	for {
+		select {
+		case answer := <-answers:
+			results[answer.ipAddress.IP.String()] = append(results[answer.ipAddress.IP.String()], answer)
+		case err := <-done:
+			network.close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			return results
+		}
+	}
+}
+
+func TestPingSenderFinishesEarly(t *testing.T) {
+	network := newFakeEchoNetwork(map[string]int{"192.0.2.1": 1, "192.0.2.2": 1, "192.0.2.3": 1}, nil)
+	start := time.Now()
+	results := fakePingSender(t, network, []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"}, 1, 2, 10*time.Second)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("pingSender took %s for hosts that all answered", elapsed)
+	}
+	if len(results) != 3 {
+		t.Errorf("got answers from %d hosts, want 3", len(results))
+	}
+}
+
+func TestPingSenderRetriesAndDuplicates(t *testing.T) {
+	network := newFakeEchoNetwork(map[string]int{"192.0.2.1": 3, "192.0.2.2": 1}, map[string]int{"192.0.2.2": 1})
+	results := fakePingSender(t, network, []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"}, 1, 1, 100*time.Millisecond)
+
+	tests := []struct {
+		address string
+		answers int
+		pings   int
+	}{
+		{"192.0.2.1", 1, 1}, // Answered three times, reported once.
+		{"192.0.2.2", 1, 2}, // Lost the first ping, answered the retry.
+		{"192.0.2.3", 0, 2}, // Never answers, pinged once more and given up on.
+	}
+	for _, test := range tests {
+		if got := len(results[test.address]); got != test.answers {
+			t.Errorf("%s: got %d answers, want %d", test.address, got, test.answers)
+		}
+		if got := network.pings(test.address); got != test.pings {
+			t.Errorf("%s: pinged %d times, want %d", test.address, got, test.pings)
+		}
+	}
+	if answer := results["192.0.2.1"]; len(answer) == 1 && (answer[0].ipState != "Up" || answer[0].method != "icmp") {
+		t.Errorf("192.0.2.1: got %+v, want an icmp answer from an Up host", answer[0])
+	}
+}
+
+func TestPingSenderCountsRounds(t *testing.T) {
+	network := newFakeEchoNetwork(map[string]int{"192.0.2.1": 2}, map[string]int{"192.0.2.2": 1})
+	network.answers["192.0.2.2"] = 1
+	results := fakePingSender(t, network, []string{"192.0.2.1", "192.0.2.2"}, 2, 0, 100*time.Millisecond)
+
+	tests := []struct {
+		address  string
+		received int
+	}{
+		{"192.0.2.1", 2},
+		{"192.0.2.2", 1},
+	}
+	for _, test := range tests {
+		answers := results[test.address]
+		if len(answers) != 1 || answers[0].stats == nil {
+			t.Errorf("%s: got %+v, want one answer with statistics", test.address, answers)
+			continue
+		}
+		if stats := answers[0].stats; stats.Sent != 2 || stats.Received != test.received {
+			t.Errorf("%s: got %d/%d replies, want %d/2", test.address, stats.Received, stats.Sent, test.received)
+		}
+	}
+}
+
+// This function returns a discovery worker which answers for the addresses in order.
+func fakeWorker(method string, addresses ...string) discoveryWorker {
+	return func(ctx context.Context, answers chan<- pingResult) error {
+		for _, address := range addresses {
+			select {
+			case answers <- pingResult{ipAddress: &net.IPAddr{IP: net.ParseIP(address)}, ipState: "Up", method: method}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	}
+}
+
+func TestRunDiscovery(t *testing.T) {
+	ips := []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("127.0.0.2"), net.ParseIP("127.0.0.3")}
+	workers := []discoveryWorker{
+		fakeWorker("icmp", "127.0.0.1", "127.0.0.1", "127.0.0.2"),
+		fakeWorker("tcp/80", "127.0.0.2", "127.0.0.1"),
+	}
+	var streamed int
+	hosts, err := runDiscovery(context.Background(), workers, ips, true, nil, func(IpData) { streamed++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if streamed != len(hosts) {
+		t.Errorf("onResult saw %d hosts, runDiscovery returned %d", streamed, len(hosts))
+	}
+	states := map[string]string{}
+	for _, host := range hosts {
+		if _, duplicate := states[host.Ipaddress]; duplicate {
+			t.Errorf("%s was reported twice", host.Ipaddress)
+		}
+		states[host.Ipaddress] = host.State
+	}
+	want := map[string]string{"127.0.0.1": "Up", "127.0.0.2": "Up", "127.0.0.3": "Down"}
+	for address, state := range want {
+		if states[address] != state {
+			t.Errorf("%s: got state %q, want %q", address, states[address], state)
+		}
+	}
+}
+
+func TestRunDiscoveryStopsOnError(t *testing.T) {
+	failure := errors.New("no socket")
+	workers := []discoveryWorker{
+		func(ctx context.Context, answers chan<- pingResult) error {
+			<-ctx.Done()
+			return ctx.Err()
+		},
+		func(ctx context.Context, answers chan<- pingResult) error {
+			return failure
+		},
+	}
+	done := make(chan error)
+	go func() {
+		_, err := runDiscovery(context.Background(), workers, nil, true, nil, nil)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != failure {
+			t.Errorf("got error %v, want %v", err, failure)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("runDiscovery did not stop the other workers after a failure")
+	}
+}
+
+func TestMaxDiscoveryTime(t *testing.T) {
+	tests := []struct {
+		name      string
+		addresses int
+		config    HostScanConfig
+		want      time.Duration
+	}{
+		{"one batch with retries", 254, HostScanConfig{PingTimeout: 2 * time.Second, Retries: 1}, 4 * time.Second},
+		{"a /16 in batches", 65534, HostScanConfig{PingTimeout: 2 * time.Second}, 255*PING_BATCH_INTERVAL + 2*time.Second},
+		{"rounds of pings", 4, HostScanConfig{PingTimeout: 2 * time.Second, Count: 5, Retries: 3}, 4*PING_INTERVAL + 2*time.Second},
+		{"slowest method wins", 256, HostScanConfig{PingTimeout: time.Second, Methods: []string{"arp", "tcp"}}, 2 * time.Second},
+		{"all-nodes group", 0, HostScanConfig{PingTimeout: 3 * time.Second, Retries: 2}, 3 * time.Second},
+	}
+	for _, test := range tests {
+		if got := MaxDiscoveryTime(test.addresses, test.config); got != test.want {
+			t.Errorf("%s: MaxDiscoveryTime() = %s, want %s", test.name, got, test.want)
+		}
+	}
+}
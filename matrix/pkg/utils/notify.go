@@ -0,0 +1,96 @@
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// How long a webhook or a shell hook may take before it is given up on.
+const HOOK_TIMEOUT = 30 * time.Second
+
+// What changed between two rounds of a watched scan, as sent to the webhooks and the shell hooks.
+type ChangeEvent struct {
+	Kind    string       `json:"kind"`
+	Target  string       `json:"target"`
+	Time    time.Time    `json:"time"`
+	Changes []ScanChange `json:"changes"`
+}
+
+// This function posts the event as JSON to the webhook, any answer but a 2xx counts as a failure.
+func PostWebhook(ctx context.Context, url string, event ChangeEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, HOOK_TIMEOUT)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "matrix")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	// Reading the body lets the connection be used again for the next round.
+	io.Copy(io.Discard, io.LimitReader(response.Body, 64*1024))
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("the webhook %s answered %s", url, response.Status)
+	}
+	return nil
+}
+
+// This function runs the shell command with the event as JSON on its standard input.
+// The kind and target of the scan and the number of changes are in the MATRIX_KIND, MATRIX_TARGET and MATRIX_CHANGES variables.
+// Whatever the command prints goes to standard error, standard output belongs to the scan results.
+func RunHook(ctx context.Context, command string, event ChangeEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, HOOK_TIMEOUT)
+	defer cancel()
+	shell := exec.CommandContext(ctx, "sh", "-c", command)
+	if runtime.GOOS == "windows" {
+		shell = exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	shell.Env = append(os.Environ(),
+		"MATRIX_KIND="+event.Kind,
+		"MATRIX_TARGET="+event.Target,
+		"MATRIX_CHANGES="+strconv.Itoa(len(event.Changes)),
+	)
+	shell.Stdin = bytes.NewReader(body)
+	shell.Stdout = os.Stderr
+	shell.Stderr = os.Stderr
+	if err := shell.Run(); err != nil {
+		return fmt.Errorf("the hook %q failed: %w", command, err)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testEvent = ChangeEvent{
+	Kind:    "portScan",
+	Target:  "192.0.2.10",
+	Time:    time.Date(2026, 10, 17, 2, 0, 0, 0, time.UTC),
+	Changes: []ScanChange{{Change: "opened", Host: "192.0.2.10", Port: 443, Protocol: "tcp", After: "https"}},
+}
+
+func TestPostWebhook(t *testing.T) {
+	received := make(chan ChangeEvent, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPost || request.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("got a %s with the content type %q", request.Method, request.Header.Get("Content-Type"))
+		}
+		var event ChangeEvent
+		if err := json.NewDecoder(request.Body).Decode(&event); err != nil {
+			t.Error(err)
+		}
+		received <- event
+		if request.URL.Path == "/broken" {
+			writer.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	if err := PostWebhook(context.Background(), server.URL+"/hook", testEvent); err != nil {
+		t.Fatal(err)
+	}
+	if event := <-received; !reflect.DeepEqual(event, testEvent) {
+		t.Errorf("the webhook got %+v, want %+v", event, testEvent)
+	}
+	if err := PostWebhook(context.Background(), server.URL+"/broken", testEvent); err == nil {
+		t.Error("a webhook answering 500 did not fail")
+	}
+	<-received
+}
+
+func TestRunHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the hook test uses a POSIX shell")
+	}
+	output := filepath.Join(t.TempDir(), "hook")
+	if err := RunHook(context.Background(), "echo $MATRIX_KIND $MATRIX_TARGET $MATRIX_CHANGES > "+output+" && cat >> "+output, testEvent); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitN(string(content), "\n", 2)
+	if lines[0] != "portScan 192.0.2.10 1" {
+		t.Errorf("the hook saw the variables %q", lines[0])
+	}
+	var event ChangeEvent
+	if err := json.Unmarshal([]byte(lines[1]), &event); err != nil || !reflect.DeepEqual(event, testEvent) {
+		t.Errorf("the hook read %q from its standard input", lines[1])
+	}
+	if err := RunHook(context.Background(), "exit 3", testEvent); err == nil {
+		t.Error("a failing hook did not report an error")
+	}
+}
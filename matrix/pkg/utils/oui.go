@@ -0,0 +1,58 @@
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	_ "embed"
+	"net"
+	"strings"
+)
+
+//go:embed oui.txt
+var ouiTable string
+
+// The vendors of the embedded OUI table, keyed by the first three bytes of the MAC address in upper case.
+var ouiVendors = parseOUI(ouiTable)
+
+// This function reads the OUI table, one prefix and vendor per line separated by a tab.
+func parseOUI(table string) map[string]string {
+	vendors := map[string]string{}
+	for _, line := range strings.Split(table, "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		prefix, vendor, found := strings.Cut(line, "\t")
+		if found {
+			vendors[strings.ToUpper(strings.TrimSpace(prefix))] = strings.TrimSpace(vendor)
+		}
+	}
+	return vendors
+}
+
+// This function names the vendor of a network card from its MAC address.
+// Virtual machines and phones often make up their own addresses, those are marked as locally administered.
+func MacVendor(mac net.HardwareAddr) string {
+	if len(mac) < 3 {
+		return ""
+	}
+	if vendor, found := ouiVendors[strings.ToUpper(mac[:3].String())]; found {
+		return vendor
+	}
+	if mac[0]&0x02 != 0 {
+		return "Locally administered"
+	}
+	return ""
+}
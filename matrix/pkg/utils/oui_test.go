@@ -0,0 +1,46 @@
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"net"
+	"testing"
+)
+
+func TestMacVendor(t *testing.T) {
+	tests := []struct {
+		mac  string
+		want string
+	}{
+		{"b8:27:eb:12:34:56", "Raspberry Pi"},
+		{"00:50:56:c0:00:08", "VMware"},
+		{"52:54:00:12:34:56", "QEMU/KVM"},
+		{"02:42:ac:11:00:02", "Locally administered"},
+		{"00:00:01:00:00:00", ""},
+	}
+	for _, test := range tests {
+		mac, err := net.ParseMAC(test.mac)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := MacVendor(mac); got != test.want {
+			t.Errorf("MacVendor(%s) = %q, want %q", test.mac, got, test.want)
+		}
+	}
+	if got := MacVendor(nil); got != "" {
+		t.Errorf("MacVendor(nil) = %q, want nothing", got)
+	}
+}
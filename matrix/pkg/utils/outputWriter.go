@@ -0,0 +1,482 @@
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// The output formats understood by the scan commands.
+var OutputFormats = []string{"table", "json", "ndjson", "csv", "xml"}
+
+/*
+nmap compatible XML structures.
+Only the parts of the nmap schema that matrix can fill are modelled here.
+*/
+type nmapRun struct {
+	XMLName  xml.Name     `xml:"nmaprun"`
+	Scanner  string       `xml:"scanner,attr"`
+	Args     string       `xml:"args,attr,omitempty"`
+	Start    int64        `xml:"start,attr"`
+	Hosts    []nmapHost   `xml:"host"`
+	RunStats nmapRunStats `xml:"runstats"`
+}
+
+type nmapHost struct {
+	Status    nmapStatus     `xml:"status"`
+	Addresses []nmapAddress  `xml:"address"`
+	Hostnames []nmapHostname `xml:"hostnames>hostname"`
+	Ports     []nmapPort     `xml:"ports>port,omitempty"`
+	Times     *nmapTimes     `xml:"times,omitempty"`
+}
+
+type nmapStatus struct {
+	State  string `xml:"state,attr"`
+	Reason string `xml:"reason,attr,omitempty"`
+}
+
+type nmapAddress struct {
+	Addr     string `xml:"addr,attr"`
+	AddrType string `xml:"addrtype,attr"`
+	Vendor   string `xml:"vendor,attr,omitempty"`
+}
+
+type nmapHostname struct {
+	Name string `xml:"name,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type nmapPort struct {
+	Protocol string       `xml:"protocol,attr"`
+	PortId   int          `xml:"portid,attr"`
+	State    nmapStatus   `xml:"state"`
+	Service  *nmapService `xml:"service,omitempty"`
+}
+
+type nmapService struct {
+	Name    string `xml:"name,attr"`
+	Version string `xml:"version,attr,omitempty"`
+	Banner  string `xml:"extrainfo,attr,omitempty"`
+}
+
+type nmapTimes struct {
+	SRTT   int64 `xml:"srtt,attr"`
+	RTTVar int64 `xml:"rttvar,attr,omitempty"`
+}
+
+type nmapRunStats struct {
+	Finished nmapFinished  `xml:"finished"`
+	Hosts    nmapHostStats `xml:"hosts"`
+}
+
+type nmapFinished struct {
+	Time int64 `xml:"time,attr"`
+}
+
+type nmapHostStats struct {
+	Up    int `xml:"up,attr"`
+	Down  int `xml:"down,attr"`
+	Total int `xml:"total,attr"`
+}
+
+/*
+Helping Functions
+*/
+// This function makes sure that the user asked for a format that we know how to write.
+func ValidateOutputFormat(format string) error {
+	for _, known := range OutputFormats {
+		if format == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown output format %q, choose one of: %s", format, strings.Join(OutputFormats, ", "))
+}
+
+// This function builds the nmap address element for a host given either as an IP or a name.
+// A name is written with the address the scan looked up for it, or as it was given when there is none.
+func nmapAddressOf(host string, address string) nmapAddress {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		ip = net.ParseIP(address)
+	}
+	if ip == nil {
+		return nmapAddress{Addr: host, AddrType: "ipv4"}
+	}
+	if ip.To4() != nil {
+		return nmapAddress{Addr: ip.String(), AddrType: "ipv4"}
+	}
+	return nmapAddress{Addr: ip.String(), AddrType: "ipv6"}
+}
+
+// This function writes the nmap document with the usual XML header.
+func writeNmapXML(writer io.Writer, run nmapRun) error {
+	run.Scanner = "matrix"
+	run.RunStats.Finished.Time = time.Now().Unix()
+	if _, err := io.WriteString(writer, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(writer)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(run); err != nil {
+		return err
+	}
+	_, err := io.WriteString(writer, "\n")
+	return err
+}
+
+/*
+Port scan output.
+*/
+func writePortTable(writer io.Writer, results []HostPorts) error {
+	table := tabwriter.NewWriter(writer, 0, 8, 1, ' ', tabwriter.AlignRight|tabwriter.Debug)
+	for index, host := range results {
+		if len(results) > 1 || len(host.Hostnames) > 0 {
+			if index > 0 {
+				fmt.Fprintln(table)
+			}
+			if len(host.Hostnames) > 0 {
+				fmt.Fprintf(table, "Host: %s (%s)\n", host.Host, strings.Join(host.Hostnames, ", "))
+			} else {
+				fmt.Fprintf(table, "Host: %s\n", host.Host)
+			}
+		}
+		fmt.Fprintln(table, "Port\tState\tReason\tService\tVersion\tBanner")
+		fmt.Fprintln(table, "----------------------------------------------")
+		for _, result := range host.Ports {
+			banner := result.Banner
+			if len(banner) > 40 {
+				banner = banner[:37] + "..."
+			}
+			fmt.Fprintf(table, "%d/%s\t%s\t%s\t%s\t%s\t%s\n", result.Port, result.Protocol, result.State, result.Reason, result.Service, result.Version, banner)
+		}
+	}
+	return table.Flush()
+}
+
+func writePortCSV(writer io.Writer, results []HostPorts) error {
+	csvWriter := csv.NewWriter(writer)
+	csvWriter.Write([]string{"host", "port", "protocol", "state", "reason", "service", "version", "banner", "hostnames"})
+	for _, host := range results {
+		for _, result := range host.Ports {
+			csvWriter.Write([]string{host.Host, strconv.Itoa(result.Port), result.Protocol, result.State, result.Reason, result.Service, result.Version, result.Banner, strings.Join(host.Hostnames, " ")})
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+func writePortXML(writer io.Writer, results []HostPorts, startTime time.Time) error {
+	run := nmapRun{Start: startTime.Unix()}
+	for _, result := range results {
+		host := nmapHost{
+			Status:    nmapStatus{State: "up"},
+			Addresses: []nmapAddress{nmapAddressOf(result.Host, result.Address)},
+		}
+		if net.ParseIP(result.Host) == nil {
+			host.Hostnames = []nmapHostname{{Name: result.Host, Type: "user"}}
+		}
+		for _, name := range result.Hostnames {
+			host.Hostnames = append(host.Hostnames, nmapHostname{Name: name, Type: "PTR"})
+		}
+		for _, scanned := range result.Ports {
+			port := nmapPort{
+				Protocol: scanned.Protocol,
+				PortId:   scanned.Port,
+				State:    nmapStatus{State: strings.ToLower(scanned.State), Reason: scanned.Reason},
+			}
+			if scanned.Service != "" {
+				port.Service = &nmapService{Name: scanned.Service, Version: scanned.Version, Banner: scanned.Banner}
+			}
+			host.Ports = append(host.Ports, port)
+		}
+		run.Hosts = append(run.Hosts, host)
+	}
+	run.RunStats.Hosts = nmapHostStats{Up: len(results), Total: len(results)}
+	return writeNmapXML(writer, run)
+}
+
+// This function writes a single port result as one NDJSON line.
+// The host goes on every line, so the results can be written while the scan is still running.
+func StreamPortResult(writer io.Writer, host string, result ScanResult) error {
+	return json.NewEncoder(writer).Encode(struct {
+		Host string `json:"host"`
+		ScanResult
+	}{Host: host, ScanResult: result})
+}
+
+// This function writes the port scan results, grouped by host, in the requested format.
+func WritePortResults(writer io.Writer, format string, results []HostPorts, startTime time.Time) error {
+	switch format {
+	case "table":
+		return writePortTable(writer, results)
+	case "json":
+		for index := range results {
+			if results[index].Ports == nil {
+				results[index].Ports = []ScanResult{}
+			}
+		}
+		if results == nil {
+			results = []HostPorts{}
+		}
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(results)
+	case "ndjson":
+		for _, host := range results {
+			for _, result := range host.Ports {
+				if err := StreamPortResult(writer, host.Host, result); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	case "csv":
+		return writePortCSV(writer, results)
+	case "xml":
+		return writePortXML(writer, results, startTime)
+	}
+	return ValidateOutputFormat(format)
+}
+
+/*
+Host scan output.
+*/
+func writeHostTable(writer io.Writer, results []IpData) error {
+	// The hardware and statistics columns are only worth their space when some host has them.
+	withMAC, withStats := false, false
+	for _, result := range results {
+		if result.MAC != "" {
+			withMAC = true
+		}
+		if result.Stats != nil {
+			withStats = true
+		}
+	}
+
+	header := []string{"IP Address", "State", "Hostname", "Response Time", "Method"}
+	if withMAC {
+		header = append(header, "MAC Address", "Vendor")
+	}
+	if withStats {
+		header = append(header, "Loss", "RTT min/avg/max/stddev")
+	}
+
+	table := tabwriter.NewWriter(writer, 1, 8, 0, ' ', tabwriter.AlignRight|tabwriter.Debug)
+	fmt.Fprintln(table, "\nScan Complete")
+	fmt.Fprintln(table, "--------------------------------------------")
+	fmt.Fprintln(table, strings.Join(header, "\t"))
+	fmt.Fprintln(table, "--------------------------------------------")
+	for _, result := range results {
+		hostnames := "N/A"
+		if len(result.Hostname) > 0 {
+			hostnames = fmt.Sprint(result.Hostname)
+		}
+		responseTime := "N/A"
+		if result.State == "Up" {
+			responseTime = result.ResponseTime.String()
+		}
+		row := []string{result.Ipaddress, result.State, hostnames, responseTime, result.Method}
+		if withMAC {
+			row = append(row, result.MAC, result.Vendor)
+		}
+		if withStats {
+			if stats := result.Stats; stats != nil {
+				row = append(row, fmt.Sprintf("%d/%d %.0f%%", stats.Received, stats.Sent, stats.Loss),
+					fmt.Sprintf("%s/%s/%s/%s", stats.Min, stats.Avg, stats.Max, stats.StdDev))
+			} else {
+				row = append(row, "", "")
+			}
+		}
+		fmt.Fprintln(table, strings.Join(row, "\t"))
+	}
+	return table.Flush()
+}
+
+// This function writes a duration in milliseconds, the unit spreadsheets are happiest with.
+func milliseconds(duration time.Duration) string {
+	return strconv.FormatFloat(float64(duration)/float64(time.Millisecond), 'f', 3, 64)
+}
+
+func writeHostCSV(writer io.Writer, results []IpData) error {
+	csvWriter := csv.NewWriter(writer)
+	csvWriter.Write([]string{"ip_address", "state", "hostnames", "response_time_ms", "method", "mac", "vendor",
+		"sent", "received", "loss_percent", "min_rtt_ms", "avg_rtt_ms", "max_rtt_ms", "stddev_rtt_ms"})
+	for _, result := range results {
+		record := []string{result.Ipaddress, result.State, strings.Join(result.Hostname, ";"), milliseconds(result.ResponseTime), result.Method, result.MAC, result.Vendor}
+		if stats := result.Stats; stats != nil {
+			record = append(record, strconv.Itoa(stats.Sent), strconv.Itoa(stats.Received), strconv.FormatFloat(stats.Loss, 'f', 1, 64),
+				milliseconds(stats.Min), milliseconds(stats.Avg), milliseconds(stats.Max), milliseconds(stats.StdDev))
+		} else {
+			record = append(record, "", "", "", "", "", "", "")
+		}
+		csvWriter.Write(record)
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+func writeHostXML(writer io.Writer, results []IpData, startTime time.Time) error {
+	run := nmapRun{Start: startTime.Unix()}
+	for _, result := range results {
+		host := nmapHost{
+			Status:    nmapStatus{State: strings.ToLower(result.State), Reason: result.Reason},
+			Addresses: []nmapAddress{nmapAddressOf(result.Ipaddress, "")},
+			Times:     &nmapTimes{SRTT: result.ResponseTime.Microseconds()},
+		}
+		if result.State != "Up" {
+			host.Times = nil
+		}
+		if result.Stats != nil {
+			host.Times.RTTVar = result.Stats.StdDev.Microseconds()
+		}
+		if result.MAC != "" {
+			host.Addresses = append(host.Addresses, nmapAddress{Addr: strings.ToUpper(result.MAC), AddrType: "mac", Vendor: result.Vendor})
+		}
+		for _, name := range result.Hostname {
+			host.Hostnames = append(host.Hostnames, nmapHostname{Name: name, Type: "PTR"})
+		}
+		if result.State == "Up" {
+			run.RunStats.Hosts.Up++
+		} else {
+			run.RunStats.Hosts.Down++
+		}
+		run.Hosts = append(run.Hosts, host)
+	}
+	run.RunStats.Hosts.Total = len(results)
+	return writeNmapXML(writer, run)
+}
+
+// This function writes a single discovered host as one NDJSON line.
+func StreamHostResult(writer io.Writer, result IpData) error {
+	return json.NewEncoder(writer).Encode(result)
+}
+
+// This function writes the discovered hosts in the requested format.
+func WriteHostResults(writer io.Writer, format string, results []IpData, startTime time.Time) error {
+	switch format {
+	case "table":
+		return writeHostTable(writer, results)
+	case "json":
+		if results == nil {
+			results = []IpData{}
+		}
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(results)
+	case "ndjson":
+		for _, result := range results {
+			if err := StreamHostResult(writer, result); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "csv":
+		return writeHostCSV(writer, results)
+	case "xml":
+		return writeHostXML(writer, results, startTime)
+	}
+	return ValidateOutputFormat(format)
+}
+
+/*
+Scan diff output.
+*/
+func writeChangeTable(writer io.Writer, changes []ScanChange) error {
+	if len(changes) == 0 {
+		_, err := fmt.Fprintln(writer, "No changes.")
+		return err
+	}
+	table := tabwriter.NewWriter(writer, 0, 8, 1, ' ', tabwriter.AlignRight|tabwriter.Debug)
+	fmt.Fprintln(table, "Change\tHost\tPort\tBefore\tAfter")
+	fmt.Fprintln(table, "----------------------------------------------")
+	for _, change := range changes {
+		port := ""
+		if change.Port != 0 {
+			port = fmt.Sprintf("%d/%s", change.Port, change.Protocol)
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n", change.Change, change.Host, port, change.Before, change.After)
+	}
+	return table.Flush()
+}
+
+// This function writes the changes between two saved scans in the requested format.
+// A diff is no scan, so there is no nmap XML for it.
+func WriteScanChanges(writer io.Writer, format string, changes []ScanChange) error {
+	switch format {
+	case "table":
+		return writeChangeTable(writer, changes)
+	case "json":
+		if changes == nil {
+			changes = []ScanChange{}
+		}
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(changes)
+	case "ndjson":
+		encoder := json.NewEncoder(writer)
+		for _, change := range changes {
+			if err := encoder.Encode(change); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "csv":
+		csvWriter := csv.NewWriter(writer)
+		csvWriter.Write([]string{"change", "host", "port", "protocol", "before", "after"})
+		for _, change := range changes {
+			port := ""
+			if change.Port != 0 {
+				port = strconv.Itoa(change.Port)
+			}
+			csvWriter.Write([]string{change.Change, change.Host, port, change.Protocol, change.Before, change.After})
+		}
+		csvWriter.Flush()
+		return csvWriter.Error()
+	case "xml":
+		return errors.New("a diff cannot be written as xml, choose table, json, ndjson or csv")
+	}
+	return ValidateOutputFormat(format)
+}
+
+// This function writes the changes of a round of a watched scan, json and ndjson get the whole event with its time.
+func WriteChangeEvent(writer io.Writer, format string, event ChangeEvent) error {
+	switch format {
+	case "table":
+		noun := "changes"
+		if len(event.Changes) == 1 {
+			noun = "change"
+		}
+		fmt.Fprintf(writer, "--- %s: %d %s in the %s of %s\n", event.Time.Format(time.RFC3339), len(event.Changes), noun, event.Kind, event.Target)
+		return writeChangeTable(writer, event.Changes)
+	case "json":
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(event)
+	case "ndjson":
+		return json.NewEncoder(writer).Encode(event)
+	}
+	return fmt.Errorf("watched scans are written as table, json or ndjson, not %s", format)
+}
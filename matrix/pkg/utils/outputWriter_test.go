@@ -0,0 +1,56 @@
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNmapAddressOf(t *testing.T) {
+	tests := []struct {
+		host    string
+		address string
+		want    nmapAddress
+	}{
+		{"192.0.2.1", "", nmapAddress{Addr: "192.0.2.1", AddrType: "ipv4"}},
+		{"2001:db8::1", "", nmapAddress{Addr: "2001:db8::1", AddrType: "ipv6"}},
+		{"printer.test", "192.0.2.77", nmapAddress{Addr: "192.0.2.77", AddrType: "ipv4"}},
+		{"printer.test", "2001:db8::77", nmapAddress{Addr: "2001:db8::77", AddrType: "ipv6"}},
+		// Without a looked up address the name is written as it was given, nothing is looked up here.
+		{"printer.test", "", nmapAddress{Addr: "printer.test", AddrType: "ipv4"}},
+	}
+	for _, test := range tests {
+		if got := nmapAddressOf(test.host, test.address); got != test.want {
+			t.Errorf("nmapAddressOf(%q, %q) = %+v, want %+v", test.host, test.address, got, test.want)
+		}
+	}
+}
+
+func TestWritePortResultsXMLUsesAddress(t *testing.T) {
+	var output bytes.Buffer
+	results := []HostPorts{{Host: "printer.test", Address: "192.0.2.77", Ports: []ScanResult{{Port: 80, Protocol: "tcp", State: "Open"}}}}
+	if err := WritePortResults(&output, "xml", results, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`addr="192.0.2.77"`, `name="printer.test" type="user"`, `portid="80"`} {
+		if !strings.Contains(output.String(), want) {
+			t.Errorf("got %s, want it to contain %s", output.String(), want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"math"
+	"time"
+)
+
+// The time between two rounds of pings when every host is pinged more than once, the same as ping uses.
+const PING_INTERVAL = 1 * time.Second
+
+// The most rounds of pings, the round number travels in the 16 bit sequence number of the echo request.
+const PING_COUNT_LIMIT = 65535
+
+// The round trip times and the packet loss of a host pinged more than once.
+type PingStats struct {
+	Sent     int           `json:"sent"`
+	Received int           `json:"received"`
+	Loss     float64       `json:"loss_percent"`
+	Min      time.Duration `json:"min_rtt_ns"`
+	Avg      time.Duration `json:"avg_rtt_ns"`
+	Max      time.Duration `json:"max_rtt_ns"`
+	StdDev   time.Duration `json:"stddev_rtt_ns"`
+}
+
+// This function sums up the round trip times of the replies to the pings sent to a host.
+func newPingStats(sent int, rtts []time.Duration) *PingStats {
+	stats := &PingStats{Sent: sent, Received: len(rtts)}
+	if sent > 0 {
+		stats.Loss = float64(sent-len(rtts)) / float64(sent) * 100
+	}
+	if len(rtts) == 0 {
+		return stats
+	}
+
+	stats.Min, stats.Max = rtts[0], rtts[0]
+	var sum time.Duration
+	for _, rtt := range rtts {
+		sum += rtt
+		if rtt < stats.Min {
+			stats.Min = rtt
+		}
+		if rtt > stats.Max {
+			stats.Max = rtt
+		}
+	}
+	stats.Avg = sum / time.Duration(len(rtts))
+
+	// The population standard deviation, like ping prints as mdev.
+	var squares float64
+	for _, rtt := range rtts {
+		difference := float64(rtt - stats.Avg)
+		squares += difference * difference
+	}
+	stats.StdDev = time.Duration(math.Sqrt(squares / float64(len(rtts))))
+	return stats
+}
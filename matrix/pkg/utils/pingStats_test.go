@@ -0,0 +1,57 @@
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewPingStats(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name string
+		sent int
+		rtts []time.Duration
+		want PingStats
+	}{
+		{
+			name: "every reply",
+			sent: 4,
+			rtts: []time.Duration{2 * ms, 4 * ms, 4 * ms, 6 * ms},
+			// The deviations are 2, 0, 0 and 2, their squares average to 2.
+			want: PingStats{Sent: 4, Received: 4, Loss: 0, Min: 2 * ms, Avg: 4 * ms, Max: 6 * ms, StdDev: 1414213},
+		},
+		{
+			name: "flaky host",
+			sent: 4,
+			rtts: []time.Duration{3 * ms},
+			want: PingStats{Sent: 4, Received: 1, Loss: 75, Min: 3 * ms, Avg: 3 * ms, Max: 3 * ms},
+		},
+		{
+			name: "silent host",
+			sent: 3,
+			want: PingStats{Sent: 3, Received: 0, Loss: 100},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := newPingStats(test.sent, test.rtts); *got != test.want {
+				t.Errorf("newPingStats(%d, %v) = %+v, want %+v", test.sent, test.rtts, *got, test.want)
+			}
+		})
+	}
+}
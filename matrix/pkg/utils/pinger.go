@@ -0,0 +1,194 @@
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// What to do when we are allowed neither kind of ICMP socket.
+var errPingPermission = errors.New("pinging needs either unprivileged ping sockets or superuser access. " +
+	"Allow your group to use ping sockets with: sudo sysctl -w net.ipv4.ping_group_range=\"0 2147483647\", " +
+	"run matrix with sudo, or look for hosts with --method tcp")
+
+// The ICMP socket as the ping senders see it, the tests talk to a fake network through it.
+type echoSocket interface {
+	send(address *net.IPAddr, seq int) error
+	receive(replies chan<- echoReply)
+	close()
+}
+
+/*
+ICMP echo pinger.
+Linux hands out datagram ICMP sockets to users whose group is inside net.ipv4.ping_group_range, no superuser access needed.
+Those are tried first and raw sockets are the fall back.
+*/
+type echoPinger struct {
+	conn4 *icmp.PacketConn
+	conn6 *icmp.PacketConn
+	// Raw sockets see every ICMP packet reaching the machine, datagram sockets only the replies meant for them.
+	privileged bool
+	id         int
+	closed     chan struct{}
+}
+
+// A reply received by the pinger.
+type echoReply struct {
+	address *net.IPAddr
+	seq     int
+	at      time.Time
+}
+
+// This function opens the ICMP sockets for the address families we are going to ping.
+func newEchoPinger(withIPv4 bool, withIPv6 bool) (*echoPinger, error) {
+	var err error
+	for _, privileged := range []bool{false, true} {
+		pinger := &echoPinger{privileged: privileged, id: os.Getpid() & 0xffff, closed: make(chan struct{})}
+		err = nil
+		if withIPv4 {
+			network := "udp4"
+			if privileged {
+				network = "ip4:icmp"
+			}
+			pinger.conn4, err = icmp.ListenPacket(network, "0.0.0.0")
+		}
+		if err == nil && withIPv6 {
+			network := "udp6"
+			if privileged {
+				network = "ip6:ipv6-icmp"
+			}
+			pinger.conn6, err = icmp.ListenPacket(network, "::")
+		}
+		if err == nil {
+			return pinger, nil
+		}
+		pinger.close()
+	}
+	return nil, pingSocketError(err)
+}
+
+// This function explains why no ICMP socket could be opened, the hint about permissions only comes with a permission error.
+// Anything else, like running out of file descriptors or a machine without IPv6, is passed on as it is.
+func pingSocketError(err error) error {
+	if errors.Is(err, os.ErrPermission) {
+		return fmt.Errorf("%w: %v", errPingPermission, err)
+	}
+	return fmt.Errorf("cannot open an ICMP socket: %w", err)
+}
+
+// This function sends an echo request to the address, seq tells the replies apart.
+func (pinger *echoPinger) send(address *net.IPAddr, seq int) error {
+	conn, messageType := pinger.conn4, icmp.Type(ipv4.ICMPTypeEcho)
+	if address.IP.To4() == nil {
+		conn, messageType = pinger.conn6, ipv6.ICMPTypeEchoRequest
+	}
+	if conn == nil {
+		return fmt.Errorf("no ICMP socket was opened for %s", address)
+	}
+	// The kernel fills in the ICMPv6 checksum itself, and the ID of datagram sockets as well.
+	request, err := (&icmp.Message{
+		Type: messageType,
+		Body: &icmp.Echo{ID: pinger.id, Seq: seq & 0xffff, Data: []byte("matrix")},
+	}).Marshal(nil)
+	if err != nil {
+		return err
+	}
+	var target net.Addr = address
+	if !pinger.privileged {
+		target = &net.UDPAddr{IP: address.IP, Zone: address.Zone}
+	}
+	_, err = conn.WriteTo(request, target)
+	return err
+}
+
+// This function passes every echo reply to the channel, until the pinger is closed.
+func (pinger *echoPinger) receive(replies chan<- echoReply) {
+	for _, conn := range []*icmp.PacketConn{pinger.conn4, pinger.conn6} {
+		if conn == nil {
+			continue
+		}
+		go func(conn *icmp.PacketConn) {
+			buffer := make([]byte, 1500)
+			for {
+				n, peer, err := conn.ReadFrom(buffer)
+				if err != nil {
+					return
+				}
+				if reply, ok := pinger.parse(buffer[:n], peer, conn == pinger.conn6); ok {
+					select {
+					case replies <- reply:
+					case <-pinger.closed:
+						return
+					}
+				}
+			}
+		}(conn)
+	}
+}
+
+// This function reads an echo reply out of a received packet.
+func (pinger *echoPinger) parse(packet []byte, peer net.Addr, fromIPv6 bool) (echoReply, bool) {
+	at := time.Now()
+	protocol := ipv4.ICMPTypeEchoReply.Protocol()
+	if fromIPv6 {
+		protocol = ipv6.ICMPTypeEchoReply.Protocol()
+	} else if len(packet) > 0 && packet[0]>>4 == 4 {
+		// Raw IPv4 sockets on Linux hand us the IP header as well, an ICMP message never starts with a 4.
+		headerLength := int(packet[0]&0x0f) * 4
+		if len(packet) < headerLength {
+			return echoReply{}, false
+		}
+		packet = packet[headerLength:]
+	}
+	message, err := icmp.ParseMessage(protocol, packet)
+	if err != nil || (message.Type != ipv4.ICMPTypeEchoReply && message.Type != ipv6.ICMPTypeEchoReply) {
+		return echoReply{}, false
+	}
+	echo, ok := message.Body.(*icmp.Echo)
+	// The kernel gives datagram sockets an ID of its own choosing and only passes them their own replies.
+	if !ok || (pinger.privileged && echo.ID != pinger.id) {
+		return echoReply{}, false
+	}
+
+	var address *net.IPAddr
+	switch peer := peer.(type) {
+	case *net.IPAddr:
+		address = peer
+	case *net.UDPAddr:
+		address = &net.IPAddr{IP: peer.IP, Zone: peer.Zone}
+	default:
+		return echoReply{}, false
+	}
+	return echoReply{address: address, seq: echo.Seq, at: at}, true
+}
+
+func (pinger *echoPinger) close() {
+	close(pinger.closed)
+	if pinger.conn4 != nil {
+		pinger.conn4.Close()
+	}
+	if pinger.conn6 != nil {
+		pinger.conn6.Close()
+	}
+}
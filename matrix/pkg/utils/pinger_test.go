@@ -0,0 +1,111 @@
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+func echoReplyPacket(t *testing.T, id int, seq int) []byte {
+	packet, err := (&icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{ID: id, Seq: seq}}).Marshal(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packet
+}
+
+func TestEchoPingerParse(t *testing.T) {
+	peer := &net.IPAddr{IP: net.ParseIP("10.0.0.7")}
+	raw := &echoPinger{privileged: true, id: 42}
+
+	// Raw IPv4 sockets deliver the IP header in front of the ICMP message.
+	header := make([]byte, 20)
+	header[0] = 0x45
+	reply, ok := raw.parse(append(header, echoReplyPacket(t, 42, 7)...), peer, false)
+	if !ok || reply.seq != 7 || !reply.address.IP.Equal(peer.IP) {
+		t.Errorf("reply behind an IP header = %+v, %v, want seq 7 from %s", reply, ok, peer)
+	}
+	if _, ok := raw.parse(echoReplyPacket(t, 43, 7), peer, false); ok {
+		t.Error("a raw socket accepted the reply to somebody else's ping")
+	}
+
+	// Datagram sockets get their ID from the kernel and only ever see their own replies.
+	datagram := &echoPinger{id: 42}
+	reply, ok = datagram.parse(echoReplyPacket(t, 1234, 3), &net.UDPAddr{IP: peer.IP}, false)
+	if !ok || reply.seq != 3 || !reply.address.IP.Equal(peer.IP) {
+		t.Errorf("reply on a datagram socket = %+v, %v, want seq 3 from %s", reply, ok, peer)
+	}
+
+	request, _ := (&icmp.Message{Type: ipv4.ICMPTypeEcho, Body: &icmp.Echo{ID: 42, Seq: 1}}).Marshal(nil)
+	if _, ok := raw.parse(request, peer, false); ok {
+		t.Error("an echo request was taken for a reply")
+	}
+}
+
+func TestEchoPingerLoopback(t *testing.T) {
+	pinger, err := newEchoPinger(true, false)
+	if err != nil {
+		t.Skipf("no ICMP socket available: %v", err)
+	}
+	defer pinger.close()
+	replies := make(chan echoReply)
+	pinger.receive(replies)
+	if err := pinger.send(&net.IPAddr{IP: net.ParseIP("127.0.0.1")}, 5); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case reply := <-replies:
+		if reply.seq != 5 || !reply.address.IP.Equal(net.ParseIP("127.0.0.1")) {
+			t.Errorf("reply = %+v, want seq 5 from 127.0.0.1", reply)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("127.0.0.1 did not answer the ping")
+	}
+}
+
+func TestPingSocketError(t *testing.T) {
+	socketError := func(errno syscall.Errno) error {
+		return &net.OpError{Op: "listen", Net: "ip4:icmp", Err: os.NewSyscallError("socket", errno)}
+	}
+	tests := []struct {
+		err        error
+		permission bool
+	}{
+		{socketError(syscall.EPERM), true},
+		{socketError(syscall.EACCES), true},
+		{socketError(syscall.EMFILE), false},
+		{socketError(syscall.EAFNOSUPPORT), false},
+	}
+	for _, test := range tests {
+		err := pingSocketError(test.err)
+		// Only one error can be wrapped before Go 1.20, the hint or the real one, the real one is always in the message.
+		if errors.Is(err, errPingPermission) != test.permission || errors.Is(err, test.err) == test.permission {
+			t.Errorf("%v: got %v, want the permission hint %v", test.err, err, test.permission)
+		}
+		if !strings.Contains(err.Error(), test.err.Error()) {
+			t.Errorf("%v: got %v, want the real error kept", test.err, err)
+		}
+	}
+}
@@ -16,90 +16,397 @@ limitations under the License.
 package utils
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
+// The number of ports scanned at once unless the user asks for something else.
+const SANITY_LIMIT = 50
+
 // Cant let you run wild with this thing. Can I?
 // Important. Don't be a douche and turn this setting up wildly,
 // you might accidentally launch a mild DOS attack.
 // Trust me Bigger is not always better.
-const SANITY_LIMIT = 50
+const CONCURRENCY_CEILING = 1000
+
+// How long a TCP connect may take before the port is given up on.
+const DIAL_TIMEOUT = 10 * time.Second
+
+// UDP gives no handshake, so a probe is retried a few times before we decide nobody is listening.
+const UDP_RETRIES = 2
+const UDP_TIMEOUT = 2 * time.Second
+
+// A SYN is resent by us rather than by the kernel, so each attempt only needs to wait a short while.
+const SYN_TIMEOUT = 1 * time.Second
+
+// The port states the user can ask to see next to the open ports.
+var PortStates = []string{"closed", "filtered", "error", "all"}
+
+// How many hosts are scanned side by side, they all share the same concurrency budget.
+const HOST_LIMIT = 8
 
 type ScanResult struct {
-	Port    int
-	State   string
-	Service string
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+	State    string `json:"state"`
+	Service  string `json:"service"`
+	Banner   string `json:"banner,omitempty"`
+	Version  string `json:"version,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// The knobs controlling how hard a port scan pushes the network.
+// Zero values fall back to the defaults above.
+type PortScanConfig struct {
+	Protocol        string
+	Concurrency     int
+	Timeout         time.Duration
+	Rate            int
+	AdaptiveTimeout bool
+	SynScan         bool
+	ProbeAll        bool
+}
+
+// The scan results of a single host.
+type HostPorts struct {
+	Host string `json:"host"`
+	// The names of a host given by address, looked up once the scan is over.
+	Hostnames []string `json:"hostnames,omitempty"`
+	// The address of a host given by name, looked up once the scan is over.
+	Address string       `json:"address,omitempty"`
+	Ports   []ScanResult `json:"ports"`
 }
 
 /*
 Helping Functions
 */
+// This function fills in the defaults and keeps the configuration within sane limits.
+func (config PortScanConfig) withDefaults() PortScanConfig {
+	if config.Protocol == "" {
+		config.Protocol = "tcp"
+	}
+	if config.Concurrency <= 0 {
+		config.Concurrency = SANITY_LIMIT
+	}
+	if config.Concurrency > CONCURRENCY_CEILING {
+		config.Concurrency = CONCURRENCY_CEILING
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DIAL_TIMEOUT
+		if config.Protocol == "udp" {
+			config.Timeout = UDP_TIMEOUT
+		}
+		if config.SynScan {
+			config.Timeout = SYN_TIMEOUT
+		}
+	}
+	return config
+}
+
+// This function decides what a failed connection attempt tells us about the port.
+// A refused connection means the host answered with a reset, so nothing is listening.
+// Silence or an ICMP unreachable usually means a firewall is in the way.
+// Anything else is a problem on our side and the state of the port stays unknown.
+func classifyDialError(err error) (string, string) {
+	var netError net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "Closed", "conn-refused"
+	case errors.As(err, &netError) && netError.Timeout():
+		return "Filtered", "no-response"
+	case errors.Is(err, syscall.EHOSTUNREACH):
+		return "Filtered", "host-unreach"
+	case errors.Is(err, syscall.ENETUNREACH):
+		return "Filtered", "net-unreach"
+	case errors.Is(err, syscall.EACCES), errors.Is(err, syscall.EPERM):
+		return "Filtered", "admin-prohibited"
+	}
+	return "Error", err.Error()
+}
+
 // This function scans a port on a particular host and returns the result in a struct.
-func scanPort(protocol string, hostname string, port int, portResultChannel chan ScanResult) {
-	result := ScanResult{Port: port, Service: protocol}
-	address := hostname + ":" + strconv.Itoa(port)
-	connect, err := net.DialTimeout(protocol, address, 10*time.Second)
+func scanPort(ctx context.Context, protocol string, hostname string, port int, tracker *rttTracker, detector serviceDetector, portResultChannel chan ScanResult) {
+	if protocol == "udp" {
+		scanUDPPort(ctx, hostname, port, tracker, portResultChannel)
+		return
+	}
+	result := ScanResult{Port: port, Protocol: protocol, Service: serviceName(port)}
+	address := net.JoinHostPort(hostname, strconv.Itoa(port))
+	dialStart := time.Now()
+	dialer := net.Dialer{Timeout: tracker.timeout()}
+	connect, err := dialer.DialContext(ctx, protocol, address)
+	if err == nil || errors.Is(err, syscall.ECONNREFUSED) {
+		// Both an accepted and a refused connection tell us how far away the host is.
+		tracker.observe(time.Since(dialStart))
+	}
 	if err != nil {
-		result.State = "Closed"
+		result.State, result.Reason = classifyDialError(err)
 		portResultChannel <- result
 		return
 	}
 	defer connect.Close()
 	result.State = "Open"
+	result.Reason = "syn-ack"
+
+	// Find out what is actually listening on the open port.
+	service := detectService(detector, connect, hostname, port)
+	result.Service = service.service
+	result.Banner = service.banner
+	result.Version = service.version
+	portResultChannel <- result
+}
+
+// This function scans a UDP port by sending it a probe and waiting for a reply.
+// A reply means the port is open, an ICMP port unreachable (seen as a refused connection) means it is closed
+// and silence means that either the service ignored us or a firewall dropped the probe.
+func scanUDPPort(ctx context.Context, hostname string, port int, tracker *rttTracker, portResultChannel chan ScanResult) {
+	probe := udpProbeFor(port)
+	result := ScanResult{Port: port, Protocol: "udp", Service: probe.service}
+	address := net.JoinHostPort(hostname, strconv.Itoa(port))
+	var dialer net.Dialer
+	connect, err := dialer.DialContext(ctx, "udp", address)
+	if err != nil {
+		result.State, result.Reason = "Error", err.Error()
+		portResultChannel <- result
+		return
+	}
+	defer connect.Close()
+
+	reply := make([]byte, 1500)
+	for attempt := 0; attempt < UDP_RETRIES && ctx.Err() == nil; attempt++ {
+		probeStart := time.Now()
+		_, err = connect.Write(probe.payload)
+		if err == nil {
+			connect.SetReadDeadline(time.Now().Add(tracker.timeout()))
+			_, err = connect.Read(reply)
+		}
+		if err == nil {
+			tracker.observe(time.Since(probeStart))
+			result.State, result.Reason = "Open", "udp-response"
+			portResultChannel <- result
+			return
+		}
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			result.State, result.Reason = "Closed", "port-unreach"
+			portResultChannel <- result
+			return
+		}
+		if state, reason := classifyDialError(err); state == "Filtered" && reason != "no-response" {
+			result.State, result.Reason = state, reason
+			portResultChannel <- result
+			return
+		}
+	}
+	result.State, result.Reason = "Open|Filtered", "no-response"
 	portResultChannel <- result
 }
 
-func resultCollector(startPort int, endPort int, portResultChannel chan ScanResult, resultCaptureChannel chan []ScanResult) {
+// This function scans a port with a half open connection, nothing but the port name is learned about the service.
+func scanSynPort(ctx context.Context, syn *synScanner, target net.IP, port int, tracker *rttTracker, portResultChannel chan ScanResult) {
+	result := ScanResult{Port: port, Protocol: "tcp", Service: serviceName(port)}
+	result.State, result.Reason = syn.probe(ctx, target, port, tracker)
+	portResultChannel <- result
+}
+
+// This function reports every port of a host that could not be scanned at all.
+func failedPorts(ports []int, protocol string, err error) []ScanResult {
 	var results []ScanResult
+	for _, port := range ports {
+		results = append(results, ScanResult{Port: port, Protocol: protocol, State: "Error", Service: serviceName(port), Reason: err.Error()})
+	}
+	return results
+}
 
-	for port := startPort; port <= endPort; port++ {
-		scanOutput := <-portResultChannel
+// This function gathers the results of a host as they come in and passes each of them on to the callback.
+// Results arriving after the scan was cancelled are dropped, their state is not to be trusted.
+func resultCollector(ctx context.Context, portResultChannel chan ScanResult, resultCaptureChannel chan []ScanResult, onResult func(ScanResult)) {
+	var results []ScanResult
+
+	for scanOutput := range portResultChannel {
+		if ctx.Err() != nil {
+			continue
+		}
+		if onResult != nil {
+			onResult(scanOutput)
+		}
 		results = append(results, scanOutput)
 	}
 
 	// Once all outputs have been collected send them back to our main thread.
 	resultCaptureChannel <- results
 	close(resultCaptureChannel)
-	close(portResultChannel)
 }
 
-/*
-Main scan controller function.
-This function spawns multiple goroutines to scan the ports on a host and then waits for them to finish before moving ahead.
-*/
-func ScanHostPorts(hostname string, startPort int, endPort int) []ScanResult {
-	speedlimitChannel := make(chan struct{}, SANITY_LIMIT)
+// This function scans the ports of one host, drawing on the given budget and rate limiter for every port it scans.
+func scanHostPorts(ctx context.Context, hostname string, ports []int, config PortScanConfig, speedlimitChannel chan struct{}, limiter *rateLimiter, onResult func(ScanResult)) []ScanResult {
+	floor := CONNECT_MIN_TIMEOUT
+	if config.SynScan || config.Protocol == "udp" {
+		floor = MIN_TIMEOUT
+	}
+	tracker := newRttTracker(floor, config.Timeout, config.AdaptiveTimeout)
+	detector := serviceDetector{ctx: ctx, limiter: limiter, tracker: tracker, probeAll: config.ProbeAll}
+	var syn *synScanner
+	var target net.IP
+	if config.SynScan {
+		var err error
+		if target, err = synTarget(hostname); err == nil {
+			syn, err = newSynScanner()
+		}
+		if err != nil {
+			results := failedPorts(ports, "tcp", err)
+			for _, result := range results {
+				if onResult != nil {
+					onResult(result)
+				}
+			}
+			return results
+		}
+		defer syn.close()
+	}
 	portResultChannel := make(chan ScanResult)
 	resultCaptureChannel := make(chan []ScanResult)
 	wg := sync.WaitGroup{}
-	defer wg.Wait()
 
 	// Start a receiver for capturing the outputs of our scan.
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		resultCollector(startPort, endPort, portResultChannel, resultCaptureChannel)
-	}()
-
-	// Scan Ports asynchronously.
-	for port := startPort; port <= endPort; port++ {
+	go resultCollector(ctx, portResultChannel, resultCaptureChannel, onResult)
+
+	// Scan Ports asynchronously until we run out of ports or the scan is cancelled.
+scanLoop:
+	for _, port := range ports {
+		select {
+		case speedlimitChannel <- struct{}{}:
+		case <-ctx.Done():
+			break scanLoop
+		}
+		if err := limiter.wait(ctx); err != nil {
+			<-speedlimitChannel
+			break
+		}
 		wg.Add(1)
-		speedlimitChannel <- struct{}{}
 		go func(hostname string, port int, returnChannel chan ScanResult) {
 			defer wg.Done()
-			scanPort("tcp", hostname, port, returnChannel)
+			if syn != nil {
+				scanSynPort(ctx, syn, target, port, tracker, returnChannel)
+			} else {
+				scanPort(ctx, config.Protocol, hostname, port, tracker, detector, returnChannel)
+			}
 			<-speedlimitChannel
 		}(hostname, port, portResultChannel)
 	}
+	wg.Wait()
+	close(portResultChannel)
 
-	// Capture and clean the TCP scan results.
+	// Capture and clean the scan results.
 	finalResult := <-resultCaptureChannel
 	sort.SliceStable(finalResult, func(i, j int) bool {
 		return finalResult[i].Port < finalResult[j].Port
 	})
 	return finalResult
 }
+
+// This function makes sure that the user only asked for port states that exist.
+func ValidatePortStates(states []string) error {
+	for _, state := range states {
+		known := false
+		for _, candidate := range PortStates {
+			if strings.ToLower(strings.TrimSpace(state)) == candidate {
+				known = true
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown port state %q, choose from: %s", state, strings.Join(PortStates, ", "))
+		}
+	}
+	return nil
+}
+
+// This function tells whether a port in the given state is one the user asked to see.
+// States such as Open|Filtered match when either of their parts was asked for.
+func MatchPortState(state string, states []string) bool {
+	for _, part := range strings.Split(strings.ToLower(state), "|") {
+		for _, wanted := range states {
+			wanted = strings.ToLower(strings.TrimSpace(wanted))
+			if wanted == "all" || wanted == part {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// This function keeps only the ports whose state the user asked to see.
+func FilterPortStates(results []HostPorts, states []string) []HostPorts {
+	filtered := make([]HostPorts, len(results))
+	for index, host := range results {
+		filtered[index].Host = host.Host
+		filtered[index].Hostnames = host.Hostnames
+		filtered[index].Address = host.Address
+		for _, result := range host.Ports {
+			if MatchPortState(result.State, states) {
+				filtered[index].Ports = append(filtered[index].Ports, result)
+			}
+		}
+	}
+	return filtered
+}
+
+/*
+Main scan controller functions.
+These functions spawn multiple goroutines to scan the ports on a host and then wait for them to finish before moving ahead.
+Every result is handed to onResult (when given) as soon as it is known.
+A cancelled scan returns the results gathered so far along with the error of the context.
+*/
+func ScanHostPorts(ctx context.Context, hostname string, ports []int, config PortScanConfig, onResult func(ScanResult)) ([]ScanResult, error) {
+	config = config.withDefaults()
+	results := scanHostPorts(ctx, hostname, ports, config, make(chan struct{}, config.Concurrency), newRateLimiter(config.Rate), onResult)
+	return results, ctx.Err()
+}
+
+// This function scans several hosts at once.
+// All the hosts share a single concurrency budget and rate limit, the results come back in the order the hosts were given.
+// The callback is never called from two goroutines at the same time.
+func ScanTargets(ctx context.Context, hosts []string, ports []int, config PortScanConfig, onResult func(string, ScanResult)) ([]HostPorts, error) {
+	config = config.withDefaults()
+	speedlimitChannel := make(chan struct{}, config.Concurrency)
+	limiter := newRateLimiter(config.Rate)
+	hostlimitChannel := make(chan struct{}, HOST_LIMIT)
+	results := make([]HostPorts, len(hosts))
+	var callbackLock sync.Mutex
+	wg := sync.WaitGroup{}
+
+	for index, hostname := range hosts {
+		select {
+		case hostlimitChannel <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return results[:index], ctx.Err()
+		}
+		wg.Add(1)
+		go func(index int, hostname string) {
+			defer wg.Done()
+			var hostCallback func(ScanResult)
+			if onResult != nil {
+				hostCallback = func(result ScanResult) {
+					callbackLock.Lock()
+					defer callbackLock.Unlock()
+					onResult(hostname, result)
+				}
+			}
+			results[index] = HostPorts{
+				Host:  hostname,
+				Ports: scanHostPorts(ctx, hostname, ports, config, speedlimitChannel, limiter, hostCallback),
+			}
+			<-hostlimitChannel
+		}(index, hostname)
+	}
+	wg.Wait()
+	return results, ctx.Err()
+}
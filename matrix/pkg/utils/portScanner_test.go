@@ -0,0 +1,131 @@
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// A dial error which timed out, the way the dialer reports one.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestClassifyDialError(t *testing.T) {
+	dialError := func(err error) error {
+		return &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", err)}
+	}
+	tests := []struct {
+		name   string
+		err    error
+		state  string
+		reason string
+	}{
+		{"refused", syscall.ECONNREFUSED, "Closed", "conn-refused"},
+		{"wrapped refused", dialError(syscall.ECONNREFUSED), "Closed", "conn-refused"},
+		{"refused wrapped twice", fmt.Errorf("scan: %w", dialError(syscall.ECONNREFUSED)), "Closed", "conn-refused"},
+		{"timeout", timeoutError{}, "Filtered", "no-response"},
+		{"wrapped timeout", &net.OpError{Op: "dial", Net: "tcp", Err: timeoutError{}}, "Filtered", "no-response"},
+		{"host unreachable", dialError(syscall.EHOSTUNREACH), "Filtered", "host-unreach"},
+		{"network unreachable", dialError(syscall.ENETUNREACH), "Filtered", "net-unreach"},
+		{"prohibited", dialError(syscall.EACCES), "Filtered", "admin-prohibited"},
+		{"our own problem", dialError(syscall.EMFILE), "Error", "dial tcp: connect: too many open files"},
+		{"anything else", errors.New("no such host"), "Error", "no such host"},
+	}
+	for _, test := range tests {
+		if state, reason := classifyDialError(test.err); state != test.state || reason != test.reason {
+			t.Errorf("%s: got %s (%s), want %s (%s)", test.name, state, reason, test.state, test.reason)
+		}
+	}
+}
+
+func TestMatchPortState(t *testing.T) {
+	tests := []struct {
+		state  string
+		states []string
+		want   bool
+	}{
+		{"Open", []string{"open"}, true},
+		{"Closed", []string{"open"}, false},
+		{"Open|Filtered", []string{"filtered"}, true},
+		{"Error", []string{"open", " ALL "}, true},
+	}
+	for _, test := range tests {
+		if got := MatchPortState(test.state, test.states); got != test.want {
+			t.Errorf("MatchPortState(%q, %q) = %v, want %v", test.state, test.states, got, test.want)
+		}
+	}
+}
+
+// This function scans a UDP port of the loopback with a short timeout and returns the result.
+func scanLoopbackUDP(t *testing.T, port int) ScanResult {
+	t.Helper()
+	results := make(chan ScanResult, 1)
+	scanUDPPort(context.Background(), "127.0.0.1", port, newRttTracker(MIN_TIMEOUT, 200*time.Millisecond, false), results)
+	return <-results
+}
+
+func TestScanUDPPort(t *testing.T) {
+	// A listener answering every datagram makes the port open.
+	answering, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer answering.Close()
+	go func() {
+		buffer := make([]byte, 1500)
+		for {
+			n, peer, err := answering.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			answering.WriteTo(buffer[:n], peer)
+		}
+	}()
+	if result := scanLoopbackUDP(t, answering.LocalAddr().(*net.UDPAddr).Port); result.State != "Open" || result.Reason != "udp-response" {
+		t.Errorf("the answering port is %s (%s), want Open", result.State, result.Reason)
+	}
+
+	// A listener reading without answering looks just like a firewall dropping the probes.
+	silent, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	if result := scanLoopbackUDP(t, silent.LocalAddr().(*net.UDPAddr).Port); result.State != "Open|Filtered" || result.Reason != "no-response" {
+		t.Errorf("the silent port is %s (%s), want Open|Filtered", result.State, result.Reason)
+	}
+
+	// Nobody listening makes the kernel send a port unreachable back, unless it rate limits its ICMP errors.
+	closed, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedPort := closed.LocalAddr().(*net.UDPAddr).Port
+	closed.Close()
+	if result := scanLoopbackUDP(t, closedPort); result.State != "Closed" && result.State != "Open|Filtered" {
+		t.Errorf("the closed port is %s (%s), want Closed", result.State, result.Reason)
+	}
+}
@@ -0,0 +1,77 @@
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePorts(t *testing.T) {
+	tests := []struct {
+		name      string
+		spec      string
+		exclude   string
+		want      []int
+		wantError bool
+	}{
+		{name: "single port", spec: "22", want: []int{22}},
+		{name: "list and range", spec: "443, 22,8000-8002", want: []int{22, 443, 8000, 8001, 8002}},
+		{name: "duplicates collapse", spec: "80,80,79-81", want: []int{79, 80, 81}},
+		{name: "exclusions", spec: "1-5", exclude: "2,4-5", want: []int{1, 3}},
+		{name: "preset with extras", spec: "WEB,22", exclude: "80-9000", want: []int{22, 9443}},
+		{name: "preset exclusion", spec: "80,443,3306", exclude: "db", want: []int{80, 443}},
+		{name: "edges of the port space", spec: "1,65535", want: []int{1, 65535}},
+		{name: "reversed range", spec: "100-90", wantError: true},
+		{name: "port zero", spec: "0-5", wantError: true},
+		{name: "port too large", spec: "65536", wantError: true},
+		{name: "unknown preset", spec: "top10", wantError: true},
+		{name: "bad exclusion", spec: "80", exclude: "http", wantError: true},
+		{name: "everything excluded", spec: "80", exclude: "web", wantError: true},
+		{name: "empty list", spec: " , ", wantError: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ports, err := ParsePorts(test.spec, test.exclude)
+			if test.wantError {
+				if err == nil {
+					t.Fatalf("ParsePorts(%q, %q) = %v, want an error", test.spec, test.exclude, ports)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParsePorts(%q, %q) failed: %v", test.spec, test.exclude, err)
+			}
+			if !reflect.DeepEqual(ports, test.want) {
+				t.Errorf("ParsePorts(%q, %q) = %v, want %v", test.spec, test.exclude, ports, test.want)
+			}
+		})
+	}
+}
+
+func TestPortPresetSizes(t *testing.T) {
+	sizes := map[string]int{"top100": 100, "top1000": 1000}
+	for name, want := range sizes {
+		ports, err := ParsePorts(name, "")
+		if err != nil {
+			t.Fatalf("preset %s does not parse: %v", name, err)
+		}
+		if len(ports) != want {
+			t.Errorf("preset %s has %d ports, want %d", name, len(ports), want)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// How often the progress line is redrawn.
+const PROGRESS_INTERVAL = 250 * time.Millisecond
+
+/*
+A live progress line for the scans.
+It shows how much of the scan is done, how many interesting results were found and how long the rest should take.
+A nil progress shows nothing, so callers do not need to check whether it is turned on.
+*/
+type Progress struct {
+	lock       sync.Mutex
+	writer     io.Writer
+	label      string
+	foundLabel string
+	total      int
+	done       int
+	found      int
+	start      time.Time
+	deadline   time.Time
+	stop       chan struct{}
+	stopped    chan struct{}
+}
+
+// This function tells whether the writer is a terminal, a progress line would only litter a file or a pipe.
+func IsTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// This function creates a progress line counting items of the given label, for example "ports" found "open".
+func NewProgress(writer io.Writer, label string, foundLabel string) *Progress {
+	return &Progress{writer: writer, label: label, foundLabel: foundLabel}
+}
+
+// This function starts redrawing the progress line for a scan of the given size.
+// Scans which always run for a fixed time can pass a deadline, it is used for the ETA instead of the pace of the scan.
+func (progress *Progress) Start(total int, deadline time.Time) {
+	if progress == nil {
+		return
+	}
+	progress.lock.Lock()
+	progress.total = total
+	progress.start = time.Now()
+	progress.deadline = deadline
+	progress.stop = make(chan struct{})
+	progress.stopped = make(chan struct{})
+	progress.lock.Unlock()
+
+	go func() {
+		defer close(progress.stopped)
+		ticker := time.NewTicker(PROGRESS_INTERVAL)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				progress.draw()
+			case <-progress.stop:
+				progress.draw()
+				fmt.Fprintln(progress.writer)
+				return
+			}
+		}
+	}()
+}
+
+// This function counts a finished item, found tells whether it was worth reporting.
+func (progress *Progress) Step(found bool) {
+	if progress == nil {
+		return
+	}
+	progress.lock.Lock()
+	defer progress.lock.Unlock()
+	progress.done++
+	if found {
+		progress.found++
+	}
+}
+
+// This function stops redrawing and leaves the final state on the screen.
+func (progress *Progress) Stop() {
+	if progress == nil {
+		return
+	}
+	progress.lock.Lock()
+	stop := progress.stop
+	progress.lock.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-progress.stopped
+}
+
+// This function estimates how long the rest of the scan takes.
+func (progress *Progress) eta() time.Duration {
+	if !progress.deadline.IsZero() {
+		if remaining := time.Until(progress.deadline); remaining > 0 {
+			return remaining
+		}
+		return 0
+	}
+	if progress.done == 0 {
+		return 0
+	}
+	elapsed := time.Since(progress.start)
+	return time.Duration(float64(elapsed) / float64(progress.done) * float64(progress.total-progress.done))
+}
+
+func (progress *Progress) draw() {
+	progress.lock.Lock()
+	defer progress.lock.Unlock()
+	fmt.Fprintf(progress.writer, "\r%s %d/%d | %s %d | ETA %s   ",
+		progress.label, progress.done, progress.total, progress.foundLabel, progress.found, progress.eta().Round(time.Second))
+}
@@ -0,0 +1,59 @@
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProgressEta(t *testing.T) {
+	progress := &Progress{total: 100, done: 25, start: time.Now().Add(-10 * time.Second)}
+	if eta := progress.eta().Round(time.Second); eta != 30*time.Second {
+		t.Errorf("a quarter done after 10s: eta = %s, want 30s", eta)
+	}
+
+	progress = &Progress{total: 100, done: 0, start: time.Now()}
+	if eta := progress.eta(); eta != 0 {
+		t.Errorf("nothing done yet: eta = %s, want 0", eta)
+	}
+
+	progress = &Progress{total: 100, start: time.Now(), deadline: time.Now().Add(-time.Second)}
+	if eta := progress.eta(); eta != 0 {
+		t.Errorf("deadline passed: eta = %s, want 0", eta)
+	}
+}
+
+func TestProgressDraw(t *testing.T) {
+	var buffer bytes.Buffer
+	progress := NewProgress(&buffer, "ports", "open")
+	progress.Start(3, time.Time{})
+	progress.Step(true)
+	progress.Step(false)
+	progress.Stop()
+	if line := buffer.String(); !strings.Contains(line, "ports 2/3 | open 1") {
+		t.Errorf("final line = %q, want it to show ports 2/3 | open 1", line)
+	}
+}
+
+func TestProgressNil(t *testing.T) {
+	var progress *Progress
+	progress.Start(10, time.Time{})
+	progress.Step(true)
+	progress.Stop()
+}
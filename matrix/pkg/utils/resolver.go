@@ -0,0 +1,414 @@
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// How many name lookups run at the same time.
+const RESOLVER_WORKERS = 16
+
+// How long a single name lookup may take, when no time is given.
+const RESOLVER_TIMEOUT = 2 * time.Second
+
+// The ports LAN devices answer name queries on when they have no PTR record.
+const (
+	MDNS_PORT    = 5353
+	NETBIOS_PORT = 137
+)
+
+// How the names of the hosts found by the scans are looked up.
+type ResolverConfig struct {
+	// Skip the name lookups altogether.
+	Disabled bool
+	// The DNS server asked instead of the one the system uses, an address with an optional port.
+	Server string
+	// How long every lookup may take, RESOLVER_TIMEOUT when zero.
+	Timeout time.Duration
+	// Ask the host itself over multicast DNS or NetBIOS when DNS knows no name for it.
+	MDNS    bool
+	NetBIOS bool
+}
+
+/*
+Reverse name resolver.
+The lookups run a few at a time with a timeout each, so a slow name server never holds up a scan.
+Every address is looked up once, everyone asking for it again gets the cached answer or waits for the lookup in flight.
+A nil resolver looks nothing up, so callers do not need to check whether it is turned on.
+*/
+type Resolver struct {
+	dns     *net.Resolver
+	timeout time.Duration
+	mdns    bool
+	netbios bool
+	slots   chan struct{}
+	lock    sync.Mutex
+	cache   map[string]*nameLookup
+}
+
+// A lookup of an address, done is closed once the names are known.
+type nameLookup struct {
+	done  chan struct{}
+	names []string
+}
+
+// This function creates the resolver described by the config, or nil when the lookups are disabled.
+func NewResolver(config ResolverConfig) (*Resolver, error) {
+	if config.Disabled {
+		return nil, nil
+	}
+	resolver := &Resolver{
+		dns:     net.DefaultResolver,
+		timeout: config.Timeout,
+		mdns:    config.MDNS,
+		netbios: config.NetBIOS,
+		slots:   make(chan struct{}, RESOLVER_WORKERS),
+		cache:   map[string]*nameLookup{},
+	}
+	if resolver.timeout <= 0 {
+		resolver.timeout = RESOLVER_TIMEOUT
+	}
+	if config.Server != "" {
+		server, err := dnsServerAddress(config.Server)
+		if err != nil {
+			return nil, err
+		}
+		// The Go resolver sends its queries wherever Dial takes them.
+		dialer := net.Dialer{}
+		resolver.dns = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network string, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, server)
+			},
+		}
+	}
+	return resolver, nil
+}
+
+// This function returns the names of the address, nil when nobody knows one.
+func (resolver *Resolver) Lookup(ctx context.Context, address string) []string {
+	if resolver == nil {
+		return nil
+	}
+	resolver.lock.Lock()
+	lookup, found := resolver.cache[address]
+	if !found {
+		lookup = &nameLookup{done: make(chan struct{})}
+		resolver.cache[address] = lookup
+	}
+	resolver.lock.Unlock()
+
+	if found {
+		select {
+		case <-lookup.done:
+			return lookup.names
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	lookup.names = resolver.resolve(ctx, address)
+	// A lookup cut short by the scan ending says nothing about the address, it is not kept.
+	if ctx.Err() != nil {
+		resolver.lock.Lock()
+		delete(resolver.cache, address)
+		resolver.lock.Unlock()
+	}
+	close(lookup.done)
+	return lookup.names
+}
+
+/*
+Helping Functions
+*/
+// This function asks the sources in turn, DNS first and then the host itself.
+func (resolver *Resolver) resolve(ctx context.Context, address string) []string {
+	select {
+	case resolver.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil
+	}
+	defer func() { <-resolver.slots }()
+
+	sources := []func(context.Context, string) []string{resolver.lookupDNS}
+	if resolver.mdns {
+		sources = append(sources, lookupMDNS)
+	}
+	if resolver.netbios {
+		sources = append(sources, lookupNetBIOS)
+	}
+	for _, source := range sources {
+		lookupCtx, cancel := context.WithTimeout(ctx, resolver.timeout)
+		names := source(lookupCtx, address)
+		cancel()
+		if len(names) > 0 {
+			return names
+		}
+	}
+	return nil
+}
+
+func (resolver *Resolver) lookupDNS(ctx context.Context, address string) []string {
+	names, err := resolver.dns.LookupAddr(ctx, address)
+	if err != nil {
+		return nil
+	}
+	return trimNames(names)
+}
+
+// This function adds the DNS port to a server given without one.
+func dnsServerAddress(server string) (string, error) {
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server, nil
+	}
+	host := strings.TrimSuffix(strings.TrimPrefix(server, "["), "]")
+	if net.ParseIP(host) == nil {
+		return "", fmt.Errorf("invalid DNS server %q, give an address with an optional port such as 1.1.1.1 or [2606:4700::1111]:53", server)
+	}
+	return net.JoinHostPort(host, "53"), nil
+}
+
+// This function drops the trailing dot of fully qualified names, the hosts file gives its names without one.
+func trimNames(names []string) []string {
+	var trimmed []string
+	for _, name := range names {
+		if name = strings.TrimSuffix(name, "."); name != "" {
+			trimmed = append(trimmed, name)
+		}
+	}
+	return trimmed
+}
+
+// This function sends a query to the host itself and returns the names read out of its answer.
+func askHost(ctx context.Context, network string, address string, port int, query []byte, parse func([]byte) []string) []string {
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(address, fmt.Sprint(port)))
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Write(query); err != nil {
+		return nil
+	}
+	buffer := make([]byte, 1500)
+	for {
+		n, err := conn.Read(buffer)
+		if err != nil {
+			return nil
+		}
+		if names := parse(buffer[:n]); len(names) > 0 {
+			return names
+		}
+	}
+}
+
+/*
+Multicast DNS.
+Devices announcing themselves over mDNS, printers, phones and the like, also answer a PTR query sent straight to them.
+*/
+// This function returns the name the PTR record of the address is kept under.
+func reverseName(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa.", ip4[3], ip4[2], ip4[1], ip4[0])
+	}
+	var name strings.Builder
+	for index := len(ip) - 1; index >= 0; index-- {
+		fmt.Fprintf(&name, "%x.%x.", ip[index]&0x0f, ip[index]>>4)
+	}
+	name.WriteString("ip6.arpa.")
+	return name.String()
+}
+
+// This function builds the PTR query for the address, asking for a unicast answer.
+func mdnsQuery(ip net.IP) ([]byte, error) {
+	name, err := dnsmessage.NewName(reverseName(ip))
+	if err != nil {
+		return nil, err
+	}
+	message := dnsmessage.Message{Questions: []dnsmessage.Question{{
+		Name:  name,
+		Type:  dnsmessage.TypePTR,
+		Class: dnsmessage.ClassINET | 1<<15,
+	}}}
+	return message.Pack()
+}
+
+// This function reads the names out of the PTR records of an answer.
+func parsePTRAnswers(packet []byte) []string {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(packet)
+	if err != nil || !header.Response {
+		return nil
+	}
+	if err := parser.SkipAllQuestions(); err != nil {
+		return nil
+	}
+	var names []string
+	for {
+		answer, err := parser.AnswerHeader()
+		if err != nil {
+			break
+		}
+		if answer.Type != dnsmessage.TypePTR {
+			if err := parser.SkipAnswer(); err != nil {
+				break
+			}
+			continue
+		}
+		record, err := parser.PTRResource()
+		if err != nil {
+			break
+		}
+		names = append(names, record.PTR.String())
+	}
+	return trimNames(names)
+}
+
+func lookupMDNS(ctx context.Context, address string) []string {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return nil
+	}
+	query, err := mdnsQuery(ip)
+	if err != nil {
+		return nil
+	}
+	return askHost(ctx, "udp", address, MDNS_PORT, query, parsePTRAnswers)
+}
+
+/*
+NetBIOS.
+Windows machines and Samba servers tell their names to anyone sending them a node status request.
+*/
+// The node status request for the wildcard name, the name is encoded as two letters for every byte.
+var netbiosStatusRequest = func() []byte {
+	request := []byte{0x4d, 0x58, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x20}
+	name := make([]byte, 16)
+	name[0] = '*'
+	for _, character := range name {
+		request = append(request, 'A'+character>>4, 'A'+character&0x0f)
+	}
+	// The end of the name, the NBSTAT type and the IN class.
+	return append(request, 0x00, 0x00, 0x21, 0x00, 0x01)
+}()
+
+// This function reads the workstation names out of a node status response.
+// Every name is 15 characters padded with spaces, a suffix telling what it is and flags telling group names apart.
+func parseNodeStatus(packet []byte) []string {
+	if len(packet) < 13 || packet[2]&0x80 == 0 {
+		return nil
+	}
+	offset := 12
+	switch {
+	case packet[offset] == 0x20:
+		offset += 34
+	case packet[offset]&0xc0 == 0xc0:
+		offset += 2
+	default:
+		return nil
+	}
+	// The type, class, TTL and length of the record.
+	offset += 10
+	if len(packet) <= offset {
+		return nil
+	}
+	count := int(packet[offset])
+	offset++
+	var names []string
+	for entry := 0; entry < count && offset+18 <= len(packet); entry, offset = entry+1, offset+18 {
+		suffix := packet[offset+15]
+		group := binary.BigEndian.Uint16(packet[offset+16:offset+18])&0x8000 != 0
+		if suffix == 0x00 && !group {
+			if name := strings.TrimRight(string(packet[offset:offset+15]), " \x00"); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+func lookupNetBIOS(ctx context.Context, address string) []string {
+	if ip := net.ParseIP(address); ip == nil || ip.To4() == nil {
+		return nil
+	}
+	return askHost(ctx, "udp4", address, NETBIOS_PORT, netbiosStatusRequest, parseNodeStatus)
+}
+
+// This function returns the address of a host name, empty when it has none or the lookup takes too long.
+func (resolver *Resolver) LookupAddress(ctx context.Context, name string) string {
+	if resolver == nil {
+		return ""
+	}
+	select {
+	case resolver.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ""
+	}
+	defer func() { <-resolver.slots }()
+	lookupCtx, cancel := context.WithTimeout(ctx, resolver.timeout)
+	defer cancel()
+	addresses, err := resolver.dns.LookupIPAddr(lookupCtx, name)
+	if err != nil || len(addresses) == 0 {
+		return ""
+	}
+	return addresses[0].IP.String()
+}
+
+// This function looks up the names of the hosts given by address which answered on some port,
+// and the addresses of the hosts given by name, all at the same time.
+func LookupHostNames(ctx context.Context, resolver *Resolver, results []HostPorts) {
+	if resolver == nil {
+		return
+	}
+	wg := sync.WaitGroup{}
+	for index := range results {
+		byName := net.ParseIP(results[index].Host) == nil
+		if !byName && !answeredAny(results[index].Ports) {
+			continue
+		}
+		wg.Add(1)
+		go func(host *HostPorts) {
+			defer wg.Done()
+			if byName {
+				host.Address = resolver.LookupAddress(ctx, host.Host)
+			} else {
+				host.Hostnames = resolver.Lookup(ctx, host.Host)
+			}
+		}(&results[index])
+	}
+	wg.Wait()
+}
+
+// This function tells whether some port of the host gave an answer, a host answering nothing may not be there.
+func answeredAny(ports []ScanResult) bool {
+	for _, port := range ports {
+		if port.State == "Open" || port.State == "Closed" {
+			return true
+		}
+	}
+	return false
+}
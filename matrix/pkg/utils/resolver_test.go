@@ -0,0 +1,244 @@
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// This function builds an answer to a PTR query with the given names.
+func ptrAnswer(t *testing.T, id uint16, question dnsmessage.Question, names ...string) []byte {
+	t.Helper()
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, Response: true, Authoritative: true})
+	builder.EnableCompression()
+	builder.StartQuestions()
+	builder.Question(question)
+	builder.StartAnswers()
+	for _, name := range names {
+		builder.PTRResource(dnsmessage.ResourceHeader{Name: question.Name, Class: dnsmessage.ClassINET, TTL: 60},
+			dnsmessage.PTRResource{PTR: dnsmessage.MustNewName(name)})
+	}
+	packet, err := builder.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packet
+}
+
+// The address the fake DNS server gives every name.
+var TEST_DNS_ADDRESS = [4]byte{192, 0, 2, 77}
+
+// This function builds an answer to an A query with TEST_DNS_ADDRESS.
+func aAnswer(t *testing.T, id uint16, question dnsmessage.Question) []byte {
+	t.Helper()
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, Response: true, Authoritative: true})
+	builder.StartQuestions()
+	builder.Question(question)
+	builder.StartAnswers()
+	builder.AResource(dnsmessage.ResourceHeader{Name: question.Name, Class: dnsmessage.ClassINET, TTL: 60},
+		dnsmessage.AResource{A: TEST_DNS_ADDRESS})
+	packet, err := builder.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packet
+}
+
+// This function starts a DNS server answering every PTR query with the name and counting the queries.
+// Every A query is answered with TEST_DNS_ADDRESS.
+func fakeDNSServer(t *testing.T, name string) (string, *int32) {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen on UDP: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	var queries int32
+	go func() {
+		buffer := make([]byte, 1500)
+		for {
+			n, peer, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			var query dnsmessage.Message
+			if query.Unpack(buffer[:n]) != nil || len(query.Questions) != 1 {
+				continue
+			}
+			var answer []byte
+			if query.Questions[0].Type == dnsmessage.TypePTR {
+				atomic.AddInt32(&queries, 1)
+				// A slow server keeps the lookups of the test in flight together.
+				time.Sleep(50 * time.Millisecond)
+				answer = ptrAnswer(t, query.Header.ID, query.Questions[0], name)
+			} else if query.Questions[0].Type == dnsmessage.TypeA {
+				answer = aAnswer(t, query.Header.ID, query.Questions[0])
+			} else {
+				answer = ptrAnswer(t, query.Header.ID, query.Questions[0])
+			}
+			conn.WriteTo(answer, peer)
+		}
+	}()
+	return conn.LocalAddr().String(), &queries
+}
+
+func TestResolverCachesLookups(t *testing.T) {
+	server, queries := fakeDNSServer(t, "printer.lan.")
+	resolver, err := NewResolver(ResolverConfig{Server: server, Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// TEST-NET addresses are in no hosts file, the name has to come from our server.
+	wg := sync.WaitGroup{}
+	for lookup := 0; lookup < 8; lookup++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if names := resolver.Lookup(context.Background(), "192.0.2.10"); !reflect.DeepEqual(names, []string{"printer.lan"}) {
+				t.Errorf("Lookup() = %v, want [printer.lan]", names)
+			}
+		}()
+	}
+	wg.Wait()
+	resolver.Lookup(context.Background(), "192.0.2.10")
+	if got := atomic.LoadInt32(queries); got != 1 {
+		t.Errorf("the server was asked %d times, want once", got)
+	}
+}
+
+func TestLookupHostNames(t *testing.T) {
+	server, _ := fakeDNSServer(t, "printer.lan.")
+	resolver, err := NewResolver(ResolverConfig{Server: server, Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := []HostPorts{
+		{Host: "printer.test"},
+		{Host: "192.0.2.10", Ports: []ScanResult{{Port: 80, State: "Open"}}},
+		{Host: "192.0.2.11", Ports: []ScanResult{{Port: 80, State: "Filtered"}}},
+	}
+	LookupHostNames(context.Background(), resolver, results)
+	// Names get their address, addresses that answered get their names.
+	if results[0].Address != "192.0.2.77" || results[0].Hostnames != nil {
+		t.Errorf("got %+v, want the address of the name", results[0])
+	}
+	if !reflect.DeepEqual(results[1].Hostnames, []string{"printer.lan"}) || results[1].Address != "" {
+		t.Errorf("got %+v, want the name of the address", results[1])
+	}
+	if results[2].Hostnames != nil {
+		t.Errorf("got %+v, a host answering nothing is not looked up", results[2])
+	}
+}
+
+func TestNilResolver(t *testing.T) {
+	resolver, err := NewResolver(ResolverConfig{Disabled: true})
+	if err != nil || resolver != nil {
+		t.Fatalf("NewResolver() = %v, %v, want no resolver", resolver, err)
+	}
+	if names := resolver.Lookup(context.Background(), "127.0.0.1"); names != nil {
+		t.Errorf("Lookup() = %v, want nothing", names)
+	}
+	if address := resolver.LookupAddress(context.Background(), "localhost"); address != "" {
+		t.Errorf("LookupAddress() = %q, want nothing", address)
+	}
+}
+
+func TestDNSServerAddress(t *testing.T) {
+	tests := []struct {
+		server  string
+		want    string
+		wantErr bool
+	}{
+		{"1.1.1.1", "1.1.1.1:53", false},
+		{"1.1.1.1:5353", "1.1.1.1:5353", false},
+		{"2606:4700::1111", "[2606:4700::1111]:53", false},
+		{"[2606:4700::1111]", "[2606:4700::1111]:53", false},
+		{"[::1]:5300", "[::1]:5300", false},
+		{"dns.example", "", true},
+	}
+	for _, test := range tests {
+		got, err := dnsServerAddress(test.server)
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("dnsServerAddress(%q) = %q, %v, want %q", test.server, got, err, test.want)
+		}
+	}
+}
+
+func TestReverseName(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"192.0.2.10", "10.2.0.192.in-addr.arpa."},
+		{"2001:db8::1", "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa."},
+	}
+	for _, test := range tests {
+		if got := reverseName(net.ParseIP(test.ip)); got != test.want {
+			t.Errorf("reverseName(%s) = %q, want %q", test.ip, got, test.want)
+		}
+	}
+}
+
+func TestParsePTRAnswers(t *testing.T) {
+	query, err := mdnsQuery(net.ParseIP("192.0.2.10"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var message dnsmessage.Message
+	if err := message.Unpack(query); err != nil {
+		t.Fatal(err)
+	}
+	if names := parsePTRAnswers(query); names != nil {
+		t.Errorf("a query was read as an answer: %v", names)
+	}
+	answer := ptrAnswer(t, 0, message.Questions[0], "phone.local.", "phone-2.local.")
+	if names := parsePTRAnswers(answer); !reflect.DeepEqual(names, []string{"phone.local", "phone-2.local"}) {
+		t.Errorf("parsePTRAnswers() = %v, want [phone.local phone-2.local]", names)
+	}
+}
+
+func TestParseNodeStatus(t *testing.T) {
+	entry := func(name string, suffix byte, flags uint16) []byte {
+		padded := []byte(name + "               ")[:15]
+		return append(padded, suffix, byte(flags>>8), byte(flags))
+	}
+	response := []byte{0x4d, 0x58, 0x84, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00}
+	// The name of the record as in the request, then its type, class, TTL and length.
+	response = append(response, netbiosStatusRequest[12:12+34]...)
+	response = append(response, 0x00, 0x21, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x41)
+	response = append(response, 3)
+	response = append(response, entry("FILESERVER", 0x00, 0x0400)...)
+	response = append(response, entry("WORKGROUP", 0x00, 0x8400)...)
+	response = append(response, entry("FILESERVER", 0x20, 0x0400)...)
+
+	if names := parseNodeStatus(response); !reflect.DeepEqual(names, []string{"FILESERVER"}) {
+		t.Errorf("parseNodeStatus() = %v, want [FILESERVER]", names)
+	}
+	if names := parseNodeStatus(response[:60]); names != nil {
+		t.Errorf("parseNodeStatus() of a cut off response = %v, want nothing", names)
+	}
+	if names := parseNodeStatus(netbiosStatusRequest); names != nil {
+		t.Errorf("the request was read as a response: %v", names)
+	}
+}
@@ -0,0 +1,276 @@
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Where the saved scans are kept, inside the home directory of the user.
+const SCAN_STORE_DIR = ".matrix/scans"
+
+/*
+A scan saved to the store.
+Host scans keep their hosts and port scans the ports of every host, along with the service found on each of them.
+*/
+type SavedScan struct {
+	ID string `json:"id"`
+	// The command that ran the scan, hostScan or portScan, and what it was pointed at.
+	Kind     string      `json:"kind"`
+	Target   string      `json:"target"`
+	Started  time.Time   `json:"started"`
+	Finished time.Time   `json:"finished"`
+	Hosts    []IpData    `json:"hosts,omitempty"`
+	Ports    []HostPorts `json:"ports,omitempty"`
+}
+
+// A difference between two saved scans.
+type ScanChange struct {
+	// new-host, gone-host, opened, closed or changed.
+	Change   string `json:"change"`
+	Host     string `json:"host"`
+	Port     int    `json:"port,omitempty"`
+	Protocol string `json:"protocol,omitempty"`
+	// What was seen before and after, the names of a host or the service on a port.
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+}
+
+// This function returns the store in the home directory of the user.
+func DefaultStoreDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, SCAN_STORE_DIR), nil
+}
+
+// This function writes the scan to the store and returns its ID, which is made from the time it started.
+func SaveScan(storeDir string, scan SavedScan) (string, error) {
+	if err := os.MkdirAll(storeDir, 0o755); err != nil {
+		return "", err
+	}
+	base := scan.Started.UTC().Format("20060102T150405Z") + "-" + scan.Kind
+	for attempt := 1; ; attempt++ {
+		scan.ID = base
+		if attempt > 1 {
+			// The padding keeps the tenth scan of a second sorting after the second one.
+			scan.ID = fmt.Sprintf("%s-%03d", base, attempt)
+		}
+		// Creating the file exclusively keeps two scans started in the same second apart.
+		file, err := os.OpenFile(filepath.Join(storeDir, scan.ID+".json"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(scan)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		return scan.ID, err
+	}
+}
+
+// This function reads a saved scan, given either by its ID or by the path of its file.
+func LoadScan(storeDir string, reference string) (SavedScan, error) {
+	path := reference
+	if !strings.ContainsRune(reference, os.PathSeparator) && !strings.HasSuffix(reference, ".json") {
+		path = filepath.Join(storeDir, reference+".json")
+	}
+	var scan SavedScan
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return scan, err
+	}
+	if err := json.Unmarshal(content, &scan); err != nil {
+		return scan, fmt.Errorf("%s is not a saved scan: %w", path, err)
+	}
+	return scan, nil
+}
+
+// This function returns the IDs of the saved scans, oldest first.
+func ListScans(storeDir string) ([]string, error) {
+	entries, err := os.ReadDir(storeDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	var ids []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+			ids = append(ids, strings.TrimSuffix(entry.Name(), ".json"))
+		}
+	}
+	// The IDs start with the time of the scan and end with a padded count, so sorting them sorts the scans.
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// This function finds the two latest saved scans of the same kind and target, the ones a nightly job wants compared.
+func LatestScanPair(storeDir string) (SavedScan, SavedScan, error) {
+	ids, err := ListScans(storeDir)
+	if err != nil {
+		return SavedScan{}, SavedScan{}, err
+	}
+	if len(ids) == 0 {
+		return SavedScan{}, SavedScan{}, fmt.Errorf("no scans are saved in %s, run a scan with --save first", storeDir)
+	}
+	latest, err := LoadScan(storeDir, ids[len(ids)-1])
+	if err != nil {
+		return SavedScan{}, SavedScan{}, err
+	}
+	for index := len(ids) - 2; index >= 0; index-- {
+		earlier, err := LoadScan(storeDir, ids[index])
+		if err != nil {
+			return SavedScan{}, SavedScan{}, err
+		}
+		if earlier.Kind == latest.Kind && earlier.Target == latest.Target {
+			return earlier, latest, nil
+		}
+	}
+	return SavedScan{}, SavedScan{}, fmt.Errorf("%s is the only saved %s of %s, there is nothing to compare it with", latest.ID, latest.Kind, latest.Target)
+}
+
+/*
+Helping Functions
+*/
+// A host as the diff sees it, its names and its open ports with the service on each.
+type diffHost struct {
+	names string
+	ports map[string]string
+}
+
+// This function boils a saved scan down to the hosts that were up and their open ports.
+func diffHosts(scan SavedScan) map[string]diffHost {
+	hosts := map[string]diffHost{}
+	for _, host := range scan.Hosts {
+		if host.State == "Up" {
+			hosts[host.Ipaddress] = diffHost{names: strings.Join(host.Hostname, ", ")}
+		}
+	}
+	for _, host := range scan.Ports {
+		ports := map[string]string{}
+		for _, port := range host.Ports {
+			if port.State == "Open" {
+				ports[fmt.Sprintf("%d/%s", port.Port, port.Protocol)] = strings.TrimSpace(port.Service + " " + port.Version)
+			}
+		}
+		// A host scanned for ports counts as up when something answers on it.
+		if len(ports) > 0 {
+			hosts[host.Host] = diffHost{names: strings.Join(host.Hostnames, ", "), ports: ports}
+		}
+	}
+	return hosts
+}
+
+// This function splits a port key such as 443/tcp back into the port and the protocol.
+func splitPortKey(key string) (int, string) {
+	var port int
+	var protocol string
+	fmt.Sscanf(strings.Replace(key, "/", " ", 1), "%d %s", &port, &protocol)
+	return port, protocol
+}
+
+// This function lists what changed from the earlier scan to the later one.
+// Hosts come and go, ports open and close, and a port keeping open with another service behind it has changed.
+func DiffScans(earlier SavedScan, later SavedScan) ([]ScanChange, error) {
+	if earlier.Kind != later.Kind {
+		return nil, fmt.Errorf("%s is a %s and %s a %s, only scans of the same kind can be compared", earlier.ID, earlier.Kind, later.ID, later.Kind)
+	}
+	before, after := diffHosts(earlier), diffHosts(later)
+	addresses := map[string]bool{}
+	for address := range before {
+		addresses[address] = true
+	}
+	for address := range after {
+		addresses[address] = true
+	}
+	sorted := make([]string, 0, len(addresses))
+	for address := range addresses {
+		sorted = append(sorted, address)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return compareAddresses(sorted[i], sorted[j]) })
+
+	var changes []ScanChange
+	for _, address := range sorted {
+		old, wasUp := before[address]
+		current, isUp := after[address]
+		switch {
+		case !wasUp:
+			changes = append(changes, ScanChange{Change: "new-host", Host: address, After: current.names})
+		case !isUp:
+			changes = append(changes, ScanChange{Change: "gone-host", Host: address, Before: old.names})
+		}
+
+		keys := map[string]bool{}
+		for key := range old.ports {
+			keys[key] = true
+		}
+		for key := range current.ports {
+			keys[key] = true
+		}
+		var portChanges []ScanChange
+		for key := range keys {
+			port, protocol := splitPortKey(key)
+			oldService, wasOpen := old.ports[key]
+			newService, isOpen := current.ports[key]
+			change := ScanChange{Host: address, Port: port, Protocol: protocol, Before: oldService, After: newService}
+			switch {
+			case !wasOpen:
+				change.Change = "opened"
+			case !isOpen:
+				change.Change = "closed"
+			case oldService != newService:
+				change.Change = "changed"
+			default:
+				continue
+			}
+			portChanges = append(portChanges, change)
+		}
+		sort.Slice(portChanges, func(i, j int) bool {
+			if portChanges[i].Port != portChanges[j].Port {
+				return portChanges[i].Port < portChanges[j].Port
+			}
+			return portChanges[i].Protocol < portChanges[j].Protocol
+		})
+		changes = append(changes, portChanges...)
+	}
+	return changes, nil
+}
+
+// This function orders addresses numerically and puts names after them.
+func compareAddresses(a string, b string) bool {
+	ipA, ipB := net.ParseIP(a).To16(), net.ParseIP(b).To16()
+	if ipA != nil && ipB != nil {
+		return bytes.Compare(ipA, ipB) < 0
+	}
+	if (ipA == nil) != (ipB == nil) {
+		return ipA != nil
+	}
+	return a < b
+}
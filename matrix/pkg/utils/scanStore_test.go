@@ -0,0 +1,146 @@
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiffScans(t *testing.T) {
+	earlier := SavedScan{ID: "earlier", Kind: "portScan", Ports: []HostPorts{
+		{Host: "192.0.2.10", Ports: []ScanResult{
+			{Port: 22, Protocol: "tcp", State: "Open", Service: "ssh", Version: "OpenSSH_8.9"},
+			{Port: 23, Protocol: "tcp", State: "Open", Service: "telnet"},
+			{Port: 80, Protocol: "tcp", State: "Open", Service: "http"},
+		}},
+		{Host: "192.0.2.9", Hostnames: []string{"old.lan"}, Ports: []ScanResult{{Port: 80, Protocol: "tcp", State: "Open", Service: "http"}}},
+		{Host: "192.0.2.11", Ports: []ScanResult{{Port: 80, Protocol: "tcp", State: "Closed"}}},
+	}}
+	later := SavedScan{ID: "later", Kind: "portScan", Ports: []HostPorts{
+		{Host: "192.0.2.10", Ports: []ScanResult{
+			{Port: 22, Protocol: "tcp", State: "Open", Service: "ssh", Version: "OpenSSH_9.6"},
+			{Port: 23, Protocol: "tcp", State: "Closed"},
+			{Port: 80, Protocol: "tcp", State: "Open", Service: "http"},
+			{Port: 443, Protocol: "tcp", State: "Open", Service: "https"},
+		}},
+		{Host: "192.0.2.11", Ports: []ScanResult{{Port: 80, Protocol: "tcp", State: "Open", Service: "http"}}},
+	}}
+
+	want := []ScanChange{
+		{Change: "gone-host", Host: "192.0.2.9", Before: "old.lan"},
+		{Change: "closed", Host: "192.0.2.9", Port: 80, Protocol: "tcp", Before: "http"},
+		{Change: "changed", Host: "192.0.2.10", Port: 22, Protocol: "tcp", Before: "ssh OpenSSH_8.9", After: "ssh OpenSSH_9.6"},
+		{Change: "closed", Host: "192.0.2.10", Port: 23, Protocol: "tcp", Before: "telnet"},
+		{Change: "opened", Host: "192.0.2.10", Port: 443, Protocol: "tcp", After: "https"},
+		{Change: "new-host", Host: "192.0.2.11"},
+		{Change: "opened", Host: "192.0.2.11", Port: 80, Protocol: "tcp", After: "http"},
+	}
+	changes, err := DiffScans(earlier, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("DiffScans() =\n%+v\nwant\n%+v", changes, want)
+	}
+	if changes, _ := DiffScans(later, later); len(changes) != 0 {
+		t.Errorf("a scan differs from itself: %+v", changes)
+	}
+	if _, err := DiffScans(earlier, SavedScan{Kind: "hostScan"}); err == nil {
+		t.Error("a port scan was compared with a host scan")
+	}
+}
+
+func TestDiffHostScans(t *testing.T) {
+	earlier := SavedScan{Kind: "hostScan", Hosts: []IpData{{Ipaddress: "192.0.2.1", State: "Up"}, {Ipaddress: "192.0.2.2", State: "Up"}}}
+	later := SavedScan{Kind: "hostScan", Hosts: []IpData{{Ipaddress: "192.0.2.1", State: "Up"}, {Ipaddress: "192.0.2.2", State: "Down"}, {Ipaddress: "192.0.2.3", State: "Up", Hostname: []string{"new.lan"}}}}
+	want := []ScanChange{
+		{Change: "gone-host", Host: "192.0.2.2"},
+		{Change: "new-host", Host: "192.0.2.3", After: "new.lan"},
+	}
+	if changes, err := DiffScans(earlier, later); err != nil || !reflect.DeepEqual(changes, want) {
+		t.Errorf("DiffScans() = %+v, %v, want %+v", changes, err, want)
+	}
+}
+
+func TestScanStore(t *testing.T) {
+	directory := t.TempDir()
+	if _, _, err := LatestScanPair(directory); err == nil {
+		t.Error("an empty store gave a pair of scans")
+	}
+	started := time.Date(2026, 10, 17, 2, 0, 0, 0, time.UTC)
+	scans := []SavedScan{
+		{Kind: "hostScan", Target: "192.0.2.0/24", Started: started},
+		{Kind: "portScan", Target: "192.0.2.1", Started: started.Add(time.Hour)},
+		{Kind: "hostScan", Target: "192.0.2.0/24", Started: started.Add(24 * time.Hour), Hosts: []IpData{{Ipaddress: "192.0.2.1", State: "Up"}}},
+		{Kind: "hostScan", Target: "192.0.2.0/24", Started: started.Add(24 * time.Hour)},
+	}
+	var ids []string
+	for _, scan := range scans {
+		id, err := SaveScan(directory, scan)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	// Two scans started in the same second still get a file each.
+	if ids[0] != "20261017T020000Z-hostScan" || ids[3] != "20261018T020000Z-hostScan-002" {
+		t.Errorf("SaveScan() gave the IDs %v", ids)
+	}
+
+	loaded, err := LoadScan(directory, ids[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.ID != ids[2] || len(loaded.Hosts) != 1 || !loaded.Started.Equal(scans[2].Started) {
+		t.Errorf("LoadScan() = %+v, want the scan saved as %s", loaded, ids[2])
+	}
+	earlier, later, err := LatestScanPair(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if earlier.ID != ids[2] || later.ID != ids[3] {
+		t.Errorf("LatestScanPair() = %s, %s, want %s, %s", earlier.ID, later.ID, ids[2], ids[3])
+	}
+}
+
+func TestScanStoreSameSecond(t *testing.T) {
+	directory := t.TempDir()
+	started := time.Date(2026, 10, 17, 2, 0, 0, 0, time.UTC)
+	var ids []string
+	for count := 0; count < 12; count++ {
+		id, err := SaveScan(directory, SavedScan{Kind: "portScan", Target: "192.0.2.1", Started: started})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	listed, err := ListScans(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(listed, ids) {
+		t.Errorf("ListScans() = %v, want the order they were saved in %v", listed, ids)
+	}
+	earlier, later, err := LatestScanPair(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if earlier.ID != ids[10] || later.ID != ids[11] {
+		t.Errorf("LatestScanPair() = %s, %s, want %s, %s", earlier.ID, later.ID, ids[10], ids[11])
+	}
+}
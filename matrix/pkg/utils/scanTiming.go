@@ -0,0 +1,134 @@
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// The adaptive timeout never drops below this, a busy host deserves a little patience.
+// Scans which resend their own probes can go as low as MIN_TIMEOUT.
+// A connect scan tries only once and the kernel waits a full second before it resends a lost SYN,
+// so anything below CONNECT_MIN_TIMEOUT would turn a single lost packet into a filtered port.
+const MIN_TIMEOUT = 100 * time.Millisecond
+const CONNECT_MIN_TIMEOUT = 1 * time.Second
+
+/*
+Token bucket rate limiter.
+Every connect attempt takes a token, tokens come back at the configured rate and at most one second worth of them can pile up.
+*/
+type rateLimiter struct {
+	lock     sync.Mutex
+	rate     float64
+	tokens   float64
+	lastFill time.Time
+}
+
+// This function creates a limiter handing out the given number of tokens per second.
+// A rate of zero means no limit, in which case no limiter is created at all.
+func newRateLimiter(rate int) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{rate: float64(rate), tokens: float64(rate), lastFill: time.Now()}
+}
+
+// This function blocks until a token is available and takes it.
+// It gives up early when the scan is cancelled.
+func (limiter *rateLimiter) wait(ctx context.Context) error {
+	if limiter == nil {
+		return ctx.Err()
+	}
+	limiter.lock.Lock()
+	now := time.Now()
+	limiter.tokens += now.Sub(limiter.lastFill).Seconds() * limiter.rate
+	if limiter.tokens > limiter.rate {
+		limiter.tokens = limiter.rate
+	}
+	limiter.lastFill = now
+
+	// Take the token right away, going into debt tells the callers after us to wait even longer.
+	limiter.tokens--
+	delay := time.Duration(0)
+	if limiter.tokens < 0 {
+		delay = time.Duration(-limiter.tokens / limiter.rate * float64(time.Second))
+	}
+	limiter.lock.Unlock()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+/*
+Adaptive timeout.
+The timeout of a host follows its round trip time the same way TCP retransmission timers do (RFC 6298),
+so a quick LAN host is not waited on for as long as a host on the other side of the world.
+*/
+type rttTracker struct {
+	lock     sync.Mutex
+	adaptive bool
+	floor    time.Duration
+	ceiling  time.Duration
+	srtt     time.Duration
+	rttvar   time.Duration
+}
+
+func newRttTracker(floor time.Duration, ceiling time.Duration, adaptive bool) *rttTracker {
+	return &rttTracker{floor: floor, ceiling: ceiling, adaptive: adaptive}
+}
+
+// This function records the round trip time of an answer from the host.
+func (tracker *rttTracker) observe(rtt time.Duration) {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+	if tracker.srtt == 0 {
+		tracker.srtt = rtt
+		tracker.rttvar = rtt / 2
+		return
+	}
+	difference := tracker.srtt - rtt
+	if difference < 0 {
+		difference = -difference
+	}
+	tracker.rttvar = (3*tracker.rttvar + difference) / 4
+	tracker.srtt = (7*tracker.srtt + rtt) / 8
+}
+
+// This function returns how long to wait for the host to answer.
+// Until the host has answered at least once the configured timeout is used.
+func (tracker *rttTracker) timeout() time.Duration {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+	if !tracker.adaptive || tracker.srtt == 0 {
+		return tracker.ceiling
+	}
+	timeout := tracker.srtt + 4*tracker.rttvar
+	if timeout < tracker.floor {
+		timeout = tracker.floor
+	}
+	if timeout > tracker.ceiling {
+		timeout = tracker.ceiling
+	}
+	return timeout
+}
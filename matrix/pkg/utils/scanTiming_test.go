@@ -0,0 +1,96 @@
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRttTrackerTimeout(t *testing.T) {
+	ms := func(value float64) time.Duration { return time.Duration(value * float64(time.Millisecond)) }
+	tests := []struct {
+		name     string
+		floor    time.Duration
+		ceiling  time.Duration
+		adaptive bool
+		samples  []time.Duration
+		want     time.Duration
+	}{
+		// Until the first answer the configured timeout is all we have.
+		{"no samples", ms(100), ms(5000), true, nil, ms(5000)},
+		{"fixed timeout", ms(100), ms(5000), false, []time.Duration{ms(10)}, ms(5000)},
+		// SRTT = R, RTTVAR = R/2, the timeout is SRTT + 4 RTTVAR.
+		{"first sample", ms(10), ms(5000), true, []time.Duration{ms(100)}, ms(300)},
+		// RTTVAR = 3/4 * 50 + 1/4 * |100 - 200| = 62.5, SRTT = 7/8 * 100 + 1/8 * 200 = 112.5.
+		{"second sample", ms(10), ms(5000), true, []time.Duration{ms(100), ms(200)}, ms(362.5)},
+		// RTTVAR = 3/4 * 62.5 + 1/4 * |112.5 - 100| = 50, SRTT = 7/8 * 112.5 + 1/8 * 100 = 110.9375.
+		{"third sample", ms(10), ms(5000), true, []time.Duration{ms(100), ms(200), ms(100)}, ms(310.9375)},
+		{"floor", ms(100), ms(5000), true, []time.Duration{ms(1), ms(1)}, ms(100)},
+		{"ceiling", ms(100), ms(1000), true, []time.Duration{ms(5000)}, ms(1000)},
+	}
+	for _, test := range tests {
+		tracker := newRttTracker(test.floor, test.ceiling, test.adaptive)
+		for _, sample := range test.samples {
+			tracker.observe(sample)
+		}
+		if got := tracker.timeout(); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRateLimiterBurst(t *testing.T) {
+	if newRateLimiter(0) != nil {
+		t.Error("a rate of zero should not limit anything")
+	}
+	// A second worth of tokens is there from the start, the next one takes a fifth of a second to come back.
+	limiter := newRateLimiter(5)
+	start := time.Now()
+	for token := 0; token < 5; token++ {
+		if err := limiter.wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("the burst took %v, want no waiting", elapsed)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := limiter.wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want the sixth token to outlast the deadline", err)
+	}
+}
+
+func TestRateLimiterCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// Without tokens the limiter waits, a cancelled scan must not.
+	limiter := newRateLimiter(1)
+	limiter.tokens = 0
+	start := time.Now()
+	if err := limiter.wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("the cancelled wait took %v", elapsed)
+	}
+	if err := (*rateLimiter)(nil).wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v from no limiter, want context.Canceled", err)
+	}
+}
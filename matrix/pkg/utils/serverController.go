@@ -16,65 +16,245 @@ limitations under the License.
 package utils
 
 import (
-	"bufio"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
 	"strconv"
-	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+// How long a client has to finish its TLS handshake.
+const TLS_HANDSHAKE_TIMEOUT = 10 * time.Second
+
+// The largest datagram the UDP server and client read.
+const MAX_DATAGRAM_SIZE = 65535
+
+// How the test servers answer their clients.
+type ServerConfig struct {
+	Port int
+	// The reply to every message, ECHO sends the message back.
+	Reply string
+	// How the TCP server tells the messages apart, one of Framings, and the size of fixed frames.
+	Framing   string
+	FrameSize int
+	// The rules answering the messages they match before Reply does, nil answers everything with Reply.
+	Rules *ResponseRules
+	// The faults the server injects on purpose, nil behaves.
+	Faults *FaultConfig
+	// The TLS configuration of the server, nil serves plaintext.
+	TLS *tls.Config
+	// The multicast group the UDP server joins, on the named interface or the one the system picks.
+	Group     string
+	Interface string
+}
+
+// This function decides how the server answers a message: the rule matching it, or else the usual reply.
+// A rule whose template fails answers nothing, the server goes on with the next message.
+func serverAnswer(message []byte, count int, peer string, config ServerConfig, reply []byte) ruleResponse {
+	response, matched, err := config.Rules.respond(message, count, peer)
+	if err != nil {
+		fmt.Printf("Client %s: cannot build the reply: %v\n", peer, err)
+		return ruleResponse{}
+	}
+	if !matched {
+		return ruleResponse{reply: reply, send: true}
+	}
+	return response
+}
+
 /*
 TCP server functions.
 */
-func processTCPClient(clientConnection net.Conn, replyMessage string, wg *sync.WaitGroup) {
-	defer wg.Done()
-	fmt.Println("Received a new client connection.")
-	for {
-		message, err := bufio.NewReader(clientConnection).ReadString('\n')
-		if err != nil {
-			fmt.Println(err)
+// This function builds the answer to a message.
+// Line chat gets its echo marked, binary protocols get their message back untouched so the client can parse it.
+func tcpReply(message []byte, config ServerConfig) []byte {
+	if config.Reply != "ECHO" {
+		return []byte(config.Reply)
+	}
+	if config.Framing == "newline" {
+		return append([]byte("Echo: "), message...)
+	}
+	return message
+}
+
+func processTCPClient(clientConnection net.Conn, config ServerConfig, faults *faultInjector) {
+	defer clientConnection.Close()
+	peer := clientConnection.RemoteAddr()
+	fmt.Printf("Received a new client connection from %s.\n", peer)
+	if tlsConnection, ok := clientConnection.(*tls.Conn); ok {
+		tlsConnection.SetDeadline(time.Now().Add(TLS_HANDSHAKE_TIMEOUT))
+		if err := tlsConnection.Handshake(); err != nil {
+			fmt.Printf("Client %s: TLS handshake failed: %v\n", peer, err)
 			return
 		}
-		// Exit when client sends EOF
-		if message == "EOF" {
+		tlsConnection.SetDeadline(time.Time{})
+		fmt.Printf("Client %s: %s\n", peer, DescribeTLS(tlsConnection.ConnectionState()))
+	}
+
+	// One framer for the whole connection, whatever it buffered belongs to the next message.
+	framer := newMessageFramer(clientConnection, config.Framing, config.FrameSize)
+	halfClosed := false
+	for count := 1; ; count++ {
+		message, err := framer.read()
+		if err != nil {
+			// Exit when client closes the connection.
+			if errors.Is(err, io.EOF) {
+				fmt.Printf("Client %s disconnected.\n", peer)
+			} else {
+				fmt.Printf("Client %s: %v\n", peer, err)
+			}
 			return
 		}
 
 		// Display the received message.
-		fmt.Println("<- ", string(message))
+		fmt.Println("<- ", describeMessage(message))
+
+		// A matching rule answers first, else the client gets its echo or the required response.
+		response := serverAnswer(message, count, peer.String(), config, tcpReply(message, config))
+		time.Sleep(response.delay + faults.latency())
+		// A half-closed connection still reads what the client sends but cannot answer anymore.
+		if response.send && !halfClosed {
+			reply, err := framer.frame(response.reply)
+			outcome := FAULT_NONE
+			if err == nil {
+				outcome, err = faults.deliver(clientConnection, reply, peer.String())
+			}
+			if err != nil || outcome == FAULT_RESET {
+				if err != nil {
+					fmt.Printf("Client %s: %v\n", peer, err)
+				}
+				return
+			}
+			halfClosed = outcome == FAULT_HALF_CLOSED
+		}
+		if response.close {
+			fmt.Printf("Closing the connection of %s.\n", peer)
+			return
+		}
+	}
+}
 
-		// Client asked for a echo server then reformat the message and send back.
-		// Else simply send back the required response.
-		if replyMessage == "ECHO" {
-			clientConnection.Write([]byte(fmt.Sprintf("Echo: %s", string(message))))
-		} else {
-			clientConnection.Write([]byte(string(replyMessage + "\n")))
+// This function serves the clients of the listener until it is closed.
+func serveTCP(listener net.Listener, config ServerConfig) error {
+	faults := newFaultInjector(config.Faults)
+	if config.TLS != nil {
+		listener = tls.NewListener(listener, config.TLS)
+	}
+	for {
+		clientConnection, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		if faults.refuse() {
+			fmt.Printf("Refused the connection of %s.\n", clientConnection.RemoteAddr())
+			resetConnection(clientConnection)
+			continue
 		}
+		go processTCPClient(clientConnection, config, faults)
 	}
 }
 
 // This function starts a TCP server with provided port and reply mechanism.
-func ServeTCP(portNumber int, replyMessage string) {
-	wg := new(sync.WaitGroup)
-	listener, err := net.Listen("tcp", ":"+strconv.Itoa(portNumber))
+func ServeTCP(config ServerConfig) error {
+	if config.Framing == "" {
+		config.Framing = "newline"
+	}
+	if err := ValidateFraming(config.Framing, config.FrameSize); err != nil {
+		return err
+	}
+	if config.Faults != nil {
+		if err := ValidateFaults(*config.Faults); err != nil {
+			return err
+		}
+	}
+	listener, err := net.Listen("tcp", ":"+strconv.Itoa(config.Port))
 	if err != nil {
-		panic(err)
+		return err
 	}
 	defer listener.Close()
-	log.Printf("TCP server started.\nPort: %d\nReply: %s\n", portNumber, replyMessage)
+	log.Printf("TCP server started.\nPort: %d\nReply: %s\nFraming: %s\nTLS: %v\n", config.Port, config.Reply, config.Framing, config.TLS != nil)
+	return serveTCP(listener, config)
+}
 
+/*
+UDP server functions.
+Every datagram is a message of its own: it gets its answer from the rules or the reply, sent back to the peer it came from.
+The server counts the datagrams of every peer, a rule closing the connection starts the count of its peer over.
+*/
+// This function serves the datagrams arriving on the connection until it is closed.
+func serveUDP(connection net.PacketConn, config ServerConfig) error {
+	faults := newFaultInjector(config.Faults)
+	counts := map[string]int{}
+	buffer := make([]byte, MAX_DATAGRAM_SIZE)
 	for {
-		clientConnection, err := listener.Accept()
+		size, address, err := connection.ReadFrom(buffer)
 		if err != nil {
-			panic(err)
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		message := append([]byte(nil), buffer[:size]...)
+		peer := address.String()
+		if counts[peer] == 0 {
+			fmt.Printf("New peer %s.\n", peer)
+		}
+		counts[peer]++
+		fmt.Printf("<- %s #%d: %s\n", peer, counts[peer], describeMessage(message))
+
+		reply := []byte(config.Reply)
+		if config.Reply == "ECHO" {
+			reply = append([]byte("Echo: "), message...)
+		}
+		response := serverAnswer(message, counts[peer], peer, config, reply)
+		if response.close {
+			delete(counts, peer)
+		}
+		if !response.send {
+			continue
+		}
+		// A delayed reply must not hold up the other peers.
+		go func(reply []byte, delay time.Duration) {
+			time.Sleep(delay)
+			if _, err := connection.WriteTo(faults.mangle(reply, peer), address); err != nil {
+				fmt.Printf("Client %s: %v\n", peer, err)
+			}
+		}(response.reply, response.delay+faults.latency())
+	}
+}
+
+// This function starts a UDP server, joined to the multicast group of the configuration if there is one.
+func ServeUDP(config ServerConfig) error {
+	if config.Faults != nil {
+		if err := ValidateFaults(*config.Faults); err != nil {
+			return err
 		}
-		wg.Add(1)
-		go processTCPClient(clientConnection, replyMessage, wg)
 	}
+	var connection net.PacketConn
+	var err error
+	if config.Group != "" {
+		connection, err = joinGroup(config.Group, config.Port, config.Interface)
+	} else {
+		connection, err = net.ListenPacket("udp", ":"+strconv.Itoa(config.Port))
+	}
+	if err != nil {
+		return err
+	}
+	defer connection.Close()
+	log.Printf("UDP server started.\nPort: %d\nReply: %s\n", config.Port, config.Reply)
+	if config.Group != "" {
+		fmt.Printf("Group: %s\n", config.Group)
+	}
+	return serveUDP(connection, config)
 }
 
 /*
@@ -82,51 +262,105 @@ Websocket server functions.
 */
 
 var upgrader = websocket.Upgrader{}
-var replyMessage string
 
-func socketHandler(w http.ResponseWriter, r *http.Request) {
-	websocketConnection, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		log.Println("Error during upgrading connection: ", err)
-		return
-	}
-	defer websocketConnection.Close()
-
-	for {
-		messageType, message, err := websocketConnection.ReadMessage()
+// This function builds the handler answering the websocket clients the way the configuration says.
+func websocketHandler(config ServerConfig) http.HandlerFunc {
+	faults := newFaultInjector(config.Faults)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if faults.refuse() {
+			fmt.Printf("Refused the connection of %s.\n", r.RemoteAddr)
+			http.Error(w, "connection refused by fault injection", http.StatusServiceUnavailable)
+			return
+		}
+		websocketConnection, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
-			if fmt.Sprintf("%s", err) == "websocket: close 1006 (abnormal closure): unexpected EOF" {
-				log.Println("Client Exited.")
-				break
-			}
-			log.Println("Error during reading client message: ", err)
-			break
+			log.Println("Error during upgrading connection: ", err)
+			return
+		}
+		defer websocketConnection.Close()
+		if r.TLS != nil {
+			fmt.Printf("Client %s: %s\n", r.RemoteAddr, DescribeTLS(*r.TLS))
 		}
-		fmt.Printf("<- %s", message)
 
-		// Writing message back to the client.
-		if replyMessage == "ECHO" {
-			reply := "Echo: " + string(message)
-			err = websocketConnection.WriteMessage(messageType, []byte(reply))
+		halfClosed := false
+		for count := 1; ; count++ {
+			messageType, message, err := websocketConnection.ReadMessage()
 			if err != nil {
-				log.Println("Error during writing message to socket: ", err)
+				if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseAbnormalClosure) {
+					log.Println("Client Exited.")
+					break
+				}
+				log.Println("Error during reading client message: ", err)
 				break
 			}
-		} else {
-			reply := replyMessage
-			err = websocketConnection.WriteMessage(messageType, []byte(reply))
-			if err != nil {
-				log.Println("Error during writing message to socket: ", err)
+			fmt.Printf("<- %s\n", describeMessage(message))
+
+			// Writing message back to the client, a matching rule answers first.
+			reply := []byte(config.Reply)
+			if config.Reply == "ECHO" {
+				reply = append([]byte("Echo: "), message...)
+			}
+			response := serverAnswer(message, count, r.RemoteAddr, config, reply)
+			time.Sleep(response.delay + faults.latency())
+			if response.send && !halfClosed {
+				// Faulty replies are written as frames straight to the connection underneath, byte by byte if need be.
+				outcome := FAULT_NONE
+				if faults == nil {
+					err = websocketConnection.WriteMessage(messageType, response.reply)
+				} else {
+					outcome, err = faults.deliver(websocketConnection.UnderlyingConn(), websocketFrame(messageType, response.reply), r.RemoteAddr)
+				}
+				if err != nil {
+					log.Println("Error during writing message to socket: ", err)
+					break
+				}
+				if outcome == FAULT_RESET {
+					break
+				}
+				halfClosed = outcome == FAULT_HALF_CLOSED
+			}
+			if response.close {
+				if !halfClosed {
+					closing := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+					websocketConnection.WriteControl(websocket.CloseMessage, closing, time.Now().Add(time.Second))
+				}
 				break
 			}
 		}
-
 	}
 }
 
 // This function starts a Websocket server.
-func ServeWebsocket(portNumber int, replyString string) {
-	replyMessage = replyString
-	http.HandleFunc("/", socketHandler)
-	log.Fatal(http.ListenAndServe("localhost:"+strconv.Itoa(portNumber), nil))
+func ServeWebsocket(config ServerConfig) error {
+	if config.Faults != nil {
+		if err := ValidateFaults(*config.Faults); err != nil {
+			return err
+		}
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/", websocketHandler(config))
+	server := &http.Server{Addr: "localhost:" + strconv.Itoa(config.Port), Handler: mux, TLSConfig: config.TLS}
+	if config.TLS != nil {
+		// The certificates are in the configuration already.
+		return server.ListenAndServeTLS("", "")
+	}
+	return server.ListenAndServe()
+}
+
+/* Helping Functions */
+
+// This function listens to a multicast group on the port, on the named interface or the one the system picks.
+func joinGroup(group string, port int, interfaceName string) (*net.UDPConn, error) {
+	ip := net.ParseIP(group)
+	if ip == nil || !ip.IsMulticast() {
+		return nil, fmt.Errorf("%s is not a multicast group", group)
+	}
+	var link *net.Interface
+	if interfaceName != "" {
+		var err error
+		if link, err = net.InterfaceByName(interfaceName); err != nil {
+			return nil, err
+		}
+	}
+	return net.ListenMulticastUDP("udp", link, &net.UDPAddr{IP: ip, Port: port})
 }
@@ -0,0 +1,284 @@
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// How long a trickled reply waits between its bytes when the configuration does not say.
+const DEFAULT_TRICKLE_DELAY = 200 * time.Millisecond
+
+/*
+Fault injection.
+The test servers can misbehave on purpose so the resilience of a client can be tested, every fault happens with its own
+probability between 0 and 1 for each reply:
+latency delays the reply by Latency and a random part of Jitter, a partial write sends only the first bytes of the reply,
+corruption flips the bits of one byte, a reset drops the connection with a RST instead of replying,
+a half-close shuts the sending side after the reply and a trickle sends the reply one byte at a time.
+RefuseAfter resets every connection after the first RefuseAfter ones as soon as it is accepted.
+*/
+type FaultConfig struct {
+	Latency         time.Duration
+	Jitter          time.Duration
+	LatencyChance   float64
+	PartialChance   float64
+	CorruptChance   float64
+	ResetChance     float64
+	HalfCloseChance float64
+	TrickleChance   float64
+	TrickleDelay    time.Duration
+	RefuseAfter     int
+	// The seed of the random faults, the same seed repeats the same faults. Zero picks one from the clock.
+	Seed int64
+}
+
+// The fault configuration as it is written in a YAML or JSON file, with durations like 250ms.
+type faultFile struct {
+	Latency         string   `json:"latency" yaml:"latency"`
+	Jitter          string   `json:"jitter" yaml:"jitter"`
+	LatencyChance   *float64 `json:"latency_chance" yaml:"latency_chance"`
+	PartialChance   float64  `json:"partial_chance" yaml:"partial_chance"`
+	CorruptChance   float64  `json:"corrupt_chance" yaml:"corrupt_chance"`
+	ResetChance     float64  `json:"reset_chance" yaml:"reset_chance"`
+	HalfCloseChance float64  `json:"half_close_chance" yaml:"half_close_chance"`
+	TrickleChance   float64  `json:"trickle_chance" yaml:"trickle_chance"`
+	TrickleDelay    string   `json:"trickle_delay" yaml:"trickle_delay"`
+	RefuseAfter     int      `json:"refuse_after" yaml:"refuse_after"`
+	Seed            int64    `json:"seed" yaml:"seed"`
+}
+
+// What happened to the connection after a faulty reply.
+type faultOutcome int
+
+const (
+	FAULT_NONE faultOutcome = iota
+	FAULT_RESET
+	FAULT_HALF_CLOSED
+)
+
+// The faults of one server, shared by all of its connections.
+type faultInjector struct {
+	config   FaultConfig
+	lock     sync.Mutex
+	random   *rand.Rand
+	accepted int64
+}
+
+// This function reads a fault configuration from a YAML or JSON file, the latency applies to every reply unless
+// latency_chance says otherwise.
+func LoadFaultConfig(path string) (FaultConfig, error) {
+	file := faultFile{}
+	if err := decodeServerFile(path, &file); err != nil {
+		return FaultConfig{}, fmt.Errorf("cannot read the faults in %s: %w", path, err)
+	}
+	config := FaultConfig{
+		LatencyChance:   1,
+		PartialChance:   file.PartialChance,
+		CorruptChance:   file.CorruptChance,
+		ResetChance:     file.ResetChance,
+		HalfCloseChance: file.HalfCloseChance,
+		TrickleChance:   file.TrickleChance,
+		TrickleDelay:    DEFAULT_TRICKLE_DELAY,
+		RefuseAfter:     file.RefuseAfter,
+		Seed:            file.Seed,
+	}
+	if file.LatencyChance != nil {
+		config.LatencyChance = *file.LatencyChance
+	}
+	for _, duration := range []struct {
+		text  string
+		value *time.Duration
+	}{{file.Latency, &config.Latency}, {file.Jitter, &config.Jitter}, {file.TrickleDelay, &config.TrickleDelay}} {
+		if duration.text == "" {
+			continue
+		}
+		value, err := time.ParseDuration(duration.text)
+		if err != nil {
+			return FaultConfig{}, fmt.Errorf("invalid duration %q in %s, write it like 250ms or 2s", duration.text, path)
+		}
+		*duration.value = value
+	}
+	if err := ValidateFaults(config); err != nil {
+		return FaultConfig{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return config, nil
+}
+
+// This function checks that the probabilities lie between 0 and 1 and that nothing is negative.
+func ValidateFaults(config FaultConfig) error {
+	for name, chance := range map[string]float64{
+		"latency": config.LatencyChance, "partial write": config.PartialChance, "corruption": config.CorruptChance,
+		"reset": config.ResetChance, "half-close": config.HalfCloseChance, "trickle": config.TrickleChance,
+	} {
+		if chance < 0 || chance > 1 {
+			return fmt.Errorf("the %s probability %v has to be between 0 and 1", name, chance)
+		}
+	}
+	if config.Latency < 0 || config.Jitter < 0 || config.TrickleDelay < 0 {
+		return errors.New("the latency, jitter and trickle delay cannot be negative")
+	}
+	if config.RefuseAfter < 0 {
+		return errors.New("the number of accepted connections cannot be negative")
+	}
+	return nil
+}
+
+// This function prepares the faults of a server, no configuration gives a nil injector that never misbehaves.
+func newFaultInjector(config *FaultConfig) *faultInjector {
+	if config == nil {
+		return nil
+	}
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	injector := &faultInjector{config: *config, random: rand.New(rand.NewSource(seed))}
+	if injector.config.TrickleDelay == 0 {
+		injector.config.TrickleDelay = DEFAULT_TRICKLE_DELAY
+	}
+	return injector
+}
+
+// This function counts an accepted connection and tells whether it has to be refused.
+func (faults *faultInjector) refuse() bool {
+	if faults == nil || faults.config.RefuseAfter == 0 {
+		return false
+	}
+	return atomic.AddInt64(&faults.accepted, 1) > int64(faults.config.RefuseAfter)
+}
+
+// This function returns how long the next reply waits.
+func (faults *faultInjector) latency() time.Duration {
+	if faults == nil || (faults.config.Latency == 0 && faults.config.Jitter == 0) || !faults.chance(faults.config.LatencyChance) {
+		return 0
+	}
+	delay := faults.config.Latency
+	if faults.config.Jitter > 0 {
+		delay += time.Duration(faults.intn(int(faults.config.Jitter) + 1))
+	}
+	return delay
+}
+
+// This function writes a reply to the connection with whatever faults it drew.
+// The peer names the client in the messages telling which fault was injected.
+func (faults *faultInjector) deliver(connection net.Conn, reply []byte, peer string) (faultOutcome, error) {
+	if faults == nil {
+		_, err := connection.Write(reply)
+		return FAULT_NONE, err
+	}
+	if faults.chance(faults.config.ResetChance) {
+		fmt.Printf("Client %s: injected a connection reset.\n", peer)
+		return FAULT_RESET, resetConnection(connection)
+	}
+	reply = faults.mangle(reply, peer)
+	var err error
+	if faults.chance(faults.config.TrickleChance) {
+		fmt.Printf("Client %s: trickling %d bytes.\n", peer, len(reply))
+		for index := range reply {
+			if index > 0 {
+				time.Sleep(faults.config.TrickleDelay)
+			}
+			if _, err = connection.Write(reply[index : index+1]); err != nil {
+				return FAULT_NONE, err
+			}
+		}
+	} else if _, err = connection.Write(reply); err != nil {
+		return FAULT_NONE, err
+	}
+	if faults.chance(faults.config.HalfCloseChance) {
+		fmt.Printf("Client %s: injected a half-close.\n", peer)
+		if closer, ok := connection.(interface{ CloseWrite() error }); ok {
+			return FAULT_HALF_CLOSED, closer.CloseWrite()
+		}
+	}
+	return FAULT_NONE, nil
+}
+
+// This function corrupts a byte of the reply or cuts it short, as the faults draw it.
+// These are the only faults of a datagram server besides the latency, a datagram cannot be reset or trickled.
+func (faults *faultInjector) mangle(reply []byte, peer string) []byte {
+	if faults == nil {
+		return reply
+	}
+	if len(reply) > 0 && faults.chance(faults.config.CorruptChance) {
+		reply = append([]byte(nil), reply...)
+		position := faults.intn(len(reply))
+		reply[position] ^= byte(1 + faults.intn(255))
+		fmt.Printf("Client %s: injected a corrupted byte at %d.\n", peer, position)
+	}
+	if len(reply) > 0 && faults.chance(faults.config.PartialChance) {
+		reply = reply[:faults.intn(len(reply))]
+		fmt.Printf("Client %s: injected a partial write of %d bytes.\n", peer, len(reply))
+	}
+	return reply
+}
+
+/* Helping Functions */
+
+// This function draws whether a fault with the probability happens.
+func (faults *faultInjector) chance(probability float64) bool {
+	if probability <= 0 {
+		return false
+	}
+	faults.lock.Lock()
+	defer faults.lock.Unlock()
+	return faults.random.Float64() < probability
+}
+
+// This function draws a random number in [0, n).
+func (faults *faultInjector) intn(n int) int {
+	faults.lock.Lock()
+	defer faults.lock.Unlock()
+	return faults.random.Intn(n)
+}
+
+// This function drops a connection with a RST instead of the orderly FIN, below TLS so no closing alert is sent first.
+func resetConnection(connection net.Conn) error {
+	underlying := connection
+	if tlsConnection, ok := connection.(*tls.Conn); ok {
+		underlying = tlsConnection.NetConn()
+	}
+	if tcpConnection, ok := underlying.(*net.TCPConn); ok {
+		tcpConnection.SetLinger(0)
+	}
+	return underlying.Close()
+}
+
+// This function builds an unmasked websocket frame, the way a server sends it, so faulty replies can be written
+// to the connection underneath the websocket byte by byte.
+func websocketFrame(messageType int, payload []byte) []byte {
+	frame := []byte{0x80 | byte(messageType)}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+	return append(frame, payload...)
+}
@@ -0,0 +1,196 @@
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestLoadFaultConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "faults.yaml")
+	os.WriteFile(path, []byte("latency: 20ms\njitter: 5ms\nreset_chance: 0.25\nrefuse_after: 3\nseed: 7\n"), 0o644)
+	config, err := LoadFaultConfig(path)
+	want := FaultConfig{Latency: 20 * time.Millisecond, Jitter: 5 * time.Millisecond, LatencyChance: 1, ResetChance: 0.25,
+		TrickleDelay: DEFAULT_TRICKLE_DELAY, RefuseAfter: 3, Seed: 7}
+	if err != nil || config != want {
+		t.Errorf("got %+v, %v, want %+v", config, err, want)
+	}
+
+	for name, content := range map[string]string{
+		"chance.json":  `{"corrupt_chance": 2}`,
+		"unknown.yaml": "reset_chanse: 0.5\n",
+		"delay.yaml":   "latency: soon\n",
+		"refuse.json":  `{"refuse_after": -1}`,
+	} {
+		path := filepath.Join(t.TempDir(), name)
+		os.WriteFile(path, []byte(content), 0o644)
+		if _, err := LoadFaultConfig(path); err == nil {
+			t.Errorf("%s: %q should be refused", name, content)
+		}
+	}
+}
+
+func TestFaultInjectorLatency(t *testing.T) {
+	if delay := (*faultInjector)(nil).latency(); delay != 0 {
+		t.Errorf("got %v without faults, want none", delay)
+	}
+	faults := newFaultInjector(&FaultConfig{Latency: 10 * time.Millisecond, Jitter: 5 * time.Millisecond, LatencyChance: 1, Seed: 1})
+	for i := 0; i < 100; i++ {
+		if delay := faults.latency(); delay < 10*time.Millisecond || delay > 15*time.Millisecond {
+			t.Fatalf("got %v, want between 10ms and 15ms", delay)
+		}
+	}
+	if delay := newFaultInjector(&FaultConfig{Latency: time.Second}).latency(); delay != 0 {
+		t.Errorf("got %v with a latency probability of 0, want none", delay)
+	}
+}
+
+// This function connects a client to a server over the loopback and returns both ends.
+func connectedPair(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close(); server.Close() })
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	return client, server
+}
+
+func TestFaultInjectorDeliver(t *testing.T) {
+	reply := []byte("0123456789")
+	tests := []struct {
+		name    string
+		config  FaultConfig
+		outcome faultOutcome
+		check   func(received []byte) bool
+	}{
+		{"none", FaultConfig{}, FAULT_NONE, func(received []byte) bool { return bytes.Equal(received, reply) }},
+		{"corrupt", FaultConfig{CorruptChance: 1}, FAULT_NONE, func(received []byte) bool {
+			differences := 0
+			for index := range received {
+				if received[index] != reply[index] {
+					differences++
+				}
+			}
+			return len(received) == len(reply) && differences == 1
+		}},
+		{"partial", FaultConfig{PartialChance: 1}, FAULT_NONE, func(received []byte) bool {
+			return len(received) < len(reply) && bytes.HasPrefix(reply, received)
+		}},
+		{"trickle", FaultConfig{TrickleChance: 1, TrickleDelay: time.Millisecond}, FAULT_NONE, func(received []byte) bool {
+			return bytes.Equal(received, reply)
+		}},
+		{"half-close", FaultConfig{HalfCloseChance: 1}, FAULT_HALF_CLOSED, func(received []byte) bool { return bytes.Equal(received, reply) }},
+		{"reset", FaultConfig{ResetChance: 1}, FAULT_RESET, func(received []byte) bool { return len(received) == 0 }},
+	}
+	for _, test := range tests {
+		test.config.Seed = 1
+		client, server := connectedPair(t)
+		outcome, err := newFaultInjector(&test.config).deliver(server, reply, "test")
+		if err != nil || outcome != test.outcome {
+			t.Errorf("%s: got outcome %v, %v, want %v", test.name, outcome, err, test.outcome)
+		}
+		// Only the half-closed and reset connections end, the others are closed here to read what was sent.
+		if outcome == FAULT_NONE {
+			server.Close()
+		}
+		received, _ := io.ReadAll(client)
+		if !test.check(received) {
+			t.Errorf("%s: the client received %q", test.name, received)
+		}
+		// A half-closed server still reads what the client sends.
+		if outcome == FAULT_HALF_CLOSED {
+			client.Write([]byte("x"))
+			server.SetReadDeadline(time.Now().Add(5 * time.Second))
+			if _, err := server.Read(make([]byte, 1)); err != nil {
+				t.Errorf("half-close: the server cannot read anymore: %v", err)
+			}
+		}
+	}
+}
+
+func TestServeTCPRefusesAfterAccepts(t *testing.T) {
+	address := startTestServer(t, ServerConfig{Reply: "ECHO", Framing: "newline", Faults: &FaultConfig{RefuseAfter: 1}})
+	for index, want := range []bool{true, false} {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		conn.Write([]byte("hello\n"))
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if answered := err == nil && line == "Echo: hello\n"; answered != want {
+			t.Errorf("connection %d: got %q, %v, answered %v, want %v", index+1, line, err, answered, want)
+		}
+	}
+}
+
+func TestWebsocketWithFaults(t *testing.T) {
+	// No fault is drawn, but the replies still go out through the frames built by hand, short and long ones.
+	server := httptest.NewServer(websocketHandler(ServerConfig{Reply: "ECHO", Faults: &FaultConfig{Seed: 1}}))
+	defer server.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for _, size := range []int{3, 300, 70000} {
+		message := bytes.Repeat([]byte("a"), size)
+		conn.WriteMessage(websocket.BinaryMessage, message)
+		messageType, reply, err := conn.ReadMessage()
+		if err != nil || messageType != websocket.BinaryMessage || !bytes.Equal(reply, append([]byte("Echo: "), message...)) {
+			t.Errorf("%d bytes: got %d bytes of type %d, %v", size, len(reply), messageType, err)
+		}
+	}
+}
+
+func TestWebsocketTrickle(t *testing.T) {
+	server := httptest.NewServer(websocketHandler(ServerConfig{Reply: "PONG", Faults: &FaultConfig{TrickleChance: 1, TrickleDelay: time.Millisecond}}))
+	defer server.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	conn.WriteMessage(websocket.TextMessage, []byte("PING"))
+	if _, reply, err := conn.ReadMessage(); err != nil || string(reply) != "PONG" {
+		t.Errorf("got %q, %v, want PONG", reply, err)
+	}
+}
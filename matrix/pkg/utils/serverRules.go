@@ -0,0 +1,247 @@
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The things a rule can do besides replying.
+var RuleActions = []string{"reply", "close"}
+
+/*
+Response rules.
+A rules file lets the test servers play a real upstream service: every message is checked against the rules in order
+and the first one that matches decides the answer, messages no rule matches get the usual reply.
+A rule matches the whole message (exact), a regular expression (regex) or the first bytes of a binary message (hex_prefix),
+a rule without any of them matches everything.
+The reply is a template, or raw bytes written in hex, and may be sent after a delay or followed by closing the connection.
+
+	rules:
+	  - exact: PING
+	    reply: PONG
+	  - regex: '^GET (?P<key>\w+)'
+	    reply: 'VALUE {{.Named.key}} {{.Count}} {{now}}'
+	    delay: 250ms
+	  - hex_prefix: 'dead'
+	    reply_hex: 'beef'
+	  - exact: QUIT
+	    reply: BYE
+	    action: close
+*/
+type ResponseRule struct {
+	Exact     string `json:"exact,omitempty" yaml:"exact,omitempty"`
+	Regex     string `json:"regex,omitempty" yaml:"regex,omitempty"`
+	HexPrefix string `json:"hex_prefix,omitempty" yaml:"hex_prefix,omitempty"`
+	Reply     string `json:"reply,omitempty" yaml:"reply,omitempty"`
+	ReplyHex  string `json:"reply_hex,omitempty" yaml:"reply_hex,omitempty"`
+	Delay     string `json:"delay,omitempty" yaml:"delay,omitempty"`
+	Action    string `json:"action,omitempty" yaml:"action,omitempty"`
+
+	pattern  *regexp.Regexp
+	prefix   []byte
+	template *template.Template
+	rawReply []byte
+	delay    time.Duration
+	hits     int64
+}
+
+// The rules of a test server, in the order they are tried.
+type ResponseRules struct {
+	Rules []*ResponseRule `json:"rules" yaml:"rules"`
+	total int64
+}
+
+// What a template can use to build a reply.
+type ruleTemplateData struct {
+	// The message as text, and the groups the regular expression captured, by number and by name.
+	Message string
+	Groups  []string
+	Named   map[string]string
+	// The messages this connection sent so far, the messages the whole server got and the messages this rule answered.
+	Count int
+	Total int64
+	Hits  int64
+	Peer  string
+	Time  time.Time
+}
+
+// How a rule answers a message.
+type ruleResponse struct {
+	reply []byte
+	send  bool
+	delay time.Duration
+	close bool
+}
+
+// The functions the reply templates can use besides the fields.
+var ruleTemplateFunctions = template.FuncMap{
+	"now":   func() string { return time.Now().Format(time.RFC3339) },
+	"unix":  func() int64 { return time.Now().Unix() },
+	"hex":   func(text string) string { return hex.EncodeToString([]byte(text)) },
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+// This function reads a rules file, YAML or JSON by its extension, and checks every rule in it.
+func LoadResponseRules(path string) (*ResponseRules, error) {
+	rules := &ResponseRules{}
+	if err := decodeServerFile(path, rules); err != nil {
+		return nil, fmt.Errorf("cannot read the rules in %s: %w", path, err)
+	}
+	for index, rule := range rules.Rules {
+		if err := rule.compile(); err != nil {
+			return nil, fmt.Errorf("rule %d in %s: %w", index+1, path, err)
+		}
+	}
+	return rules, nil
+}
+
+// This function checks a rule and prepares its pattern, prefix and template.
+func (rule *ResponseRule) compile() error {
+	matchers := 0
+	for _, matcher := range []string{rule.Exact, rule.Regex, rule.HexPrefix} {
+		if matcher != "" {
+			matchers++
+		}
+	}
+	if matchers > 1 {
+		return errors.New("a rule matches with one of exact, regex or hex_prefix, not several")
+	}
+	if rule.Reply != "" && rule.ReplyHex != "" {
+		return errors.New("a rule replies with either reply or reply_hex")
+	}
+	var err error
+	if rule.Regex != "" {
+		if rule.pattern, err = regexp.Compile(rule.Regex); err != nil {
+			return err
+		}
+	}
+	if rule.HexPrefix != "" {
+		if rule.prefix, err = hex.DecodeString(strings.ReplaceAll(rule.HexPrefix, " ", "")); err != nil {
+			return fmt.Errorf("invalid hex_prefix: %w", err)
+		}
+	}
+	if rule.ReplyHex != "" {
+		if rule.rawReply, err = hex.DecodeString(strings.ReplaceAll(rule.ReplyHex, " ", "")); err != nil {
+			return fmt.Errorf("invalid reply_hex: %w", err)
+		}
+	}
+	if rule.Reply != "" {
+		if rule.template, err = template.New("reply").Funcs(ruleTemplateFunctions).Parse(rule.Reply); err != nil {
+			return err
+		}
+	}
+	if rule.Delay != "" {
+		if rule.delay, err = time.ParseDuration(rule.Delay); err != nil || rule.delay < 0 {
+			return fmt.Errorf("invalid delay %q, write it like 250ms or 2s", rule.Delay)
+		}
+	}
+	switch rule.Action {
+	case "", "reply", "close":
+	default:
+		return fmt.Errorf("unknown action %q, choose one of: %s", rule.Action, strings.Join(RuleActions, ", "))
+	}
+	return nil
+}
+
+// This function tells whether the rule matches the message, with the groups a regular expression captured.
+func (rule *ResponseRule) match(message []byte) ([]string, map[string]string, bool) {
+	switch {
+	case rule.pattern != nil:
+		groups := rule.pattern.FindSubmatch(message)
+		if groups == nil {
+			return nil, nil, false
+		}
+		captured := make([]string, len(groups))
+		named := map[string]string{}
+		for index, group := range groups {
+			captured[index] = string(group)
+			if name := rule.pattern.SubexpNames()[index]; name != "" {
+				named[name] = string(group)
+			}
+		}
+		return captured, named, true
+	case rule.prefix != nil:
+		return nil, nil, bytes.HasPrefix(message, rule.prefix)
+	case rule.Exact != "":
+		return nil, nil, string(message) == rule.Exact
+	}
+	return nil, nil, true
+}
+
+// This function finds the rule answering the message and builds the answer.
+// count is the number of messages the connection sent so far, this one included.
+// A nil set of rules answers nothing, so the servers can ask it whether or not a rules file was given.
+func (rules *ResponseRules) respond(message []byte, count int, peer string) (ruleResponse, bool, error) {
+	if rules == nil {
+		return ruleResponse{}, false, nil
+	}
+	total := atomic.AddInt64(&rules.total, 1)
+	for _, rule := range rules.Rules {
+		groups, named, matched := rule.match(message)
+		if !matched {
+			continue
+		}
+		response := ruleResponse{delay: rule.delay, close: rule.Action == "close"}
+		hits := atomic.AddInt64(&rule.hits, 1)
+		switch {
+		case rule.rawReply != nil:
+			response.reply, response.send = rule.rawReply, true
+		case rule.template != nil:
+			var reply bytes.Buffer
+			data := ruleTemplateData{Message: string(message), Groups: groups, Named: named, Count: count, Total: total, Hits: hits, Peer: peer, Time: time.Now()}
+			if err := rule.template.Execute(&reply, data); err != nil {
+				return ruleResponse{}, true, err
+			}
+			response.reply, response.send = reply.Bytes(), true
+		}
+		return response, true, nil
+	}
+	return ruleResponse{}, false, nil
+}
+
+/* Helping Functions */
+
+// This function decodes a server file, JSON when it is named .json and YAML otherwise.
+// Unknown keys are refused, a misspelled one would otherwise be quietly ignored.
+func decodeServerFile(path string, into interface{}) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.DisallowUnknownFields()
+		return decoder.Decode(into)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	return decoder.Decode(into)
+}
@@ -0,0 +1,161 @@
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const TEST_RULES_YAML = `
+rules:
+  - exact: PING
+    reply: PONG
+  - regex: '^GET (?P<key>\w+)'
+    reply: 'VALUE {{.Named.key}} {{index .Groups 1}} {{.Count}} {{.Hits}}'
+  - hex_prefix: 'de ad'
+    reply_hex: 'be ef'
+  - exact: SLOW
+    reply: LATE
+    delay: 50ms
+  - exact: QUIT
+    reply: BYE
+    action: close
+`
+
+// This function writes the rules to a file in a test directory and loads them.
+func loadTestRules(t *testing.T, name string, content string) *ResponseRules {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := LoadResponseRules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rules
+}
+
+func TestResponseRulesRespond(t *testing.T) {
+	rules := loadTestRules(t, "rules.yaml", TEST_RULES_YAML)
+	tests := []struct {
+		message string
+		count   int
+		matched bool
+		reply   string
+		close   bool
+	}{
+		{"PING", 1, true, "PONG", false},
+		{"PING!", 2, false, "", false},
+		{"GET name", 3, true, "VALUE name name 3 1", false},
+		{"GET other", 4, true, "VALUE other other 4 2", false},
+		{"\xde\xad\x00", 5, true, "\xbe\xef", false},
+		{"QUIT", 6, true, "BYE", true},
+	}
+	for _, test := range tests {
+		response, matched, err := rules.respond([]byte(test.message), test.count, "peer")
+		if err != nil || matched != test.matched || string(response.reply) != test.reply || response.close != test.close {
+			t.Errorf("%q: got %q, matched %v, close %v, %v", test.message, response.reply, matched, response.close, err)
+		}
+	}
+	if response, _, _ := rules.respond([]byte("SLOW"), 1, "peer"); response.delay != 50*time.Millisecond {
+		t.Errorf("got a delay of %v, want 50ms", response.delay)
+	}
+	if _, matched, _ := (*ResponseRules)(nil).respond([]byte("PING"), 1, "peer"); matched {
+		t.Error("no rules should match nothing")
+	}
+}
+
+func TestLoadResponseRulesJSON(t *testing.T) {
+	rules := loadTestRules(t, "rules.json", `{"rules": [{"regex": "^(\\d+)$", "reply": "{{.Message}}={{.Total}}"}, {"reply": "ANY"}]}`)
+	for _, test := range []struct{ message, reply string }{{"42", "42=1"}, {"x", "ANY"}, {"7", "7=3"}} {
+		if response, _, err := rules.respond([]byte(test.message), 1, "peer"); err != nil || string(response.reply) != test.reply {
+			t.Errorf("%q: got %q, %v, want %q", test.message, response.reply, err, test.reply)
+		}
+	}
+}
+
+func TestLoadResponseRulesRejectsInvalidRules(t *testing.T) {
+	tests := map[string]string{
+		"rules.yaml": "rules:\n  - exakt: PING\n",
+		"two.yaml":   "rules:\n  - exact: PING\n    regex: PING\n",
+		"regex.json": `{"rules": [{"regex": "("}]}`,
+		"hex.yaml":   "rules:\n  - hex_prefix: xyz\n",
+		"delay.yaml": "rules:\n  - delay: soon\n",
+		"act.yaml":   "rules:\n  - action: explode\n",
+		"tmpl.yaml":  "rules:\n  - reply: '{{.Message'\n",
+	}
+	for name, content := range tests {
+		path := filepath.Join(t.TempDir(), name)
+		os.WriteFile(path, []byte(content), 0o644)
+		if _, err := LoadResponseRules(path); err == nil {
+			t.Errorf("%s: %q should be refused", name, content)
+		}
+	}
+}
+
+func TestServeTCPWithRules(t *testing.T) {
+	rules := loadTestRules(t, "rules.yaml", TEST_RULES_YAML)
+	address := startTestServer(t, ServerConfig{Reply: "ECHO", Framing: "newline", Rules: rules})
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	// Messages no rule matches still get the usual echo, QUIT gets its answer and then the connection is closed.
+	conn.Write([]byte("PING\nhello\nGET key\nQUIT\n"))
+	reader := bufio.NewReader(conn)
+	for _, want := range []string{"PONG\n", "Echo: hello\n", "VALUE key key 3 1\n", "BYE\n"} {
+		if line, err := reader.ReadString('\n'); err != nil || line != want {
+			t.Errorf("got %q, %v, want %q", line, err, want)
+		}
+	}
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("got %v, want the server to close the connection", err)
+	}
+}
+
+func TestWebsocketWithRules(t *testing.T) {
+	rules := loadTestRules(t, "rules.yaml", TEST_RULES_YAML)
+	server := httptest.NewServer(websocketHandler(ServerConfig{Reply: "ECHO", Rules: rules}))
+	defer server.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for _, test := range []struct{ message, reply string }{{"PING", "PONG"}, {"hello", "Echo: hello"}, {"QUIT", "BYE"}} {
+		conn.WriteMessage(websocket.TextMessage, []byte(test.message))
+		if _, reply, err := conn.ReadMessage(); err != nil || string(reply) != test.reply {
+			t.Errorf("%q: got %q, %v, want %q", test.message, reply, err, test.reply)
+		}
+	}
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Errorf("got %v, want the server to close the connection", err)
+	}
+}
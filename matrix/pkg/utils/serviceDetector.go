@@ -0,0 +1,375 @@
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The longest we wait for a service to greet us and the longest a single probe is allowed to take.
+// Both are cut down further when the scan itself uses a shorter timeout.
+const BANNER_TIMEOUT = 2 * time.Second
+const PROBE_TIMEOUT = 3 * time.Second
+
+// The longest banner we keep, anything after this is cut off.
+const BANNER_LIMIT = 256
+
+type serviceInfo struct {
+	service string
+	banner  string
+	version string
+}
+
+// A probe talks to a port that stayed silent and tries to recognise what is listening.
+// Probes listing a port are tried on that port, the rest are only tried when the user asks for every probe.
+type serviceProbe struct {
+	ports []int
+	run   func(detector serviceDetector, hostname string, address string) (serviceInfo, bool)
+}
+
+// The scan settings the detection has to respect, every extra connection it opens goes through the same limits as the scan.
+type serviceDetector struct {
+	ctx      context.Context
+	limiter  *rateLimiter
+	tracker  *rttTracker
+	probeAll bool
+	// Host discovery only cares that a port answered, not what is behind it.
+	disabled bool
+}
+
+var serviceProbes = []serviceProbe{
+	{ports: []int{80, 81, 591, 3000, 5000, 8000, 8008, 8080, 8081, 8888, 9000}, run: httpProbe},
+	{ports: []int{443, 465, 636, 853, 993, 995, 8443, 9443}, run: tlsProbe},
+	{ports: []int{6379}, run: redisProbe},
+}
+
+// The fallback table used when a port neither greets us nor answers any probe.
+var wellKnownServices = map[int]string{
+	7:     "echo",
+	20:    "ftp-data",
+	21:    "ftp",
+	22:    "ssh",
+	23:    "telnet",
+	25:    "smtp",
+	53:    "domain",
+	67:    "dhcps",
+	68:    "dhcpc",
+	69:    "tftp",
+	80:    "http",
+	88:    "kerberos",
+	110:   "pop3",
+	111:   "rpcbind",
+	119:   "nntp",
+	123:   "ntp",
+	135:   "msrpc",
+	137:   "netbios-ns",
+	139:   "netbios-ssn",
+	143:   "imap",
+	161:   "snmp",
+	179:   "bgp",
+	389:   "ldap",
+	443:   "https",
+	445:   "microsoft-ds",
+	465:   "smtps",
+	514:   "syslog",
+	515:   "printer",
+	587:   "submission",
+	631:   "ipp",
+	636:   "ldaps",
+	853:   "domain-s",
+	873:   "rsync",
+	993:   "imaps",
+	995:   "pop3s",
+	1080:  "socks",
+	1433:  "ms-sql-s",
+	1521:  "oracle",
+	1723:  "pptp",
+	1883:  "mqtt",
+	2049:  "nfs",
+	2181:  "zookeeper",
+	2375:  "docker",
+	3000:  "ppp",
+	3306:  "mysql",
+	3389:  "ms-wbt-server",
+	5000:  "upnp",
+	5432:  "postgresql",
+	5353:  "mdns",
+	5672:  "amqp",
+	5900:  "vnc",
+	5984:  "couchdb",
+	6379:  "redis",
+	6443:  "kubernetes",
+	8000:  "http-alt",
+	8080:  "http-proxy",
+	8443:  "https-alt",
+	9000:  "cslistener",
+	9092:  "kafka",
+	9200:  "elasticsearch",
+	11211: "memcache",
+	27017: "mongodb",
+}
+
+/*
+Helping Functions
+*/
+// This function cleans a raw banner so that it can be safely printed on a terminal.
+func cleanBanner(raw []byte) string {
+	if len(raw) > BANNER_LIMIT {
+		raw = raw[:BANNER_LIMIT]
+	}
+	cleaned := strings.Map(func(r rune) rune {
+		if r < 32 || r > 126 {
+			return '.'
+		}
+		return r
+	}, strings.TrimRight(string(raw), "\r\n"))
+	return cleaned
+}
+
+// This function returns the given limit, or the scan timeout when that one is shorter.
+func (detector serviceDetector) timeout(limit time.Duration) time.Duration {
+	if scanTimeout := detector.tracker.timeout(); scanTimeout < limit {
+		return scanTimeout
+	}
+	return limit
+}
+
+// This function opens a new connection for a probe, waiting for the rate limiter first.
+func (detector serviceDetector) dial(address string) (net.Conn, error) {
+	if err := detector.limiter.wait(detector.ctx); err != nil {
+		return nil, err
+	}
+	dialer := net.Dialer{Timeout: detector.timeout(PROBE_TIMEOUT)}
+	return dialer.DialContext(detector.ctx, "tcp", address)
+}
+
+// This function reads whatever the service sends us right after the connection is made.
+func readBanner(connect net.Conn, wait time.Duration) []byte {
+	connect.SetReadDeadline(time.Now().Add(wait))
+	buffer := make([]byte, 1024)
+	n, _ := connect.Read(buffer)
+	return buffer[:n]
+}
+
+// This function identifies the services which introduce themselves as soon as we connect.
+func identifyBanner(raw []byte, port int) (serviceInfo, bool) {
+	banner := cleanBanner(raw)
+	firstLine := strings.SplitN(strings.TrimRight(string(raw), "\r\n"), "\n", 2)[0]
+	firstLine = strings.TrimSpace(firstLine)
+	upperLine := strings.ToUpper(firstLine)
+
+	switch {
+	case strings.HasPrefix(firstLine, "SSH-"):
+		// SSH-2.0-OpenSSH_8.9p1 Ubuntu-3
+		parts := strings.SplitN(firstLine, "-", 3)
+		version := ""
+		if len(parts) == 3 {
+			version = parts[2]
+		}
+		return serviceInfo{service: "ssh", banner: banner, version: version}, true
+
+	case strings.HasPrefix(firstLine, "220"):
+		// FTP and SMTP both greet with a 220 code, the text or the port tells them apart.
+		version := strings.TrimSpace(strings.TrimLeft(firstLine[3:], "- "))
+		service := "ftp"
+		if strings.Contains(upperLine, "SMTP") || strings.Contains(upperLine, "MAIL") || port == 25 || port == 587 || port == 465 {
+			service = "smtp"
+		}
+		return serviceInfo{service: service, banner: banner, version: version}, true
+
+	case strings.HasPrefix(firstLine, "+OK"):
+		return serviceInfo{service: "pop3", banner: banner, version: strings.TrimSpace(firstLine[3:])}, true
+
+	case strings.HasPrefix(firstLine, "* OK"):
+		return serviceInfo{service: "imap", banner: banner, version: strings.TrimSpace(firstLine[4:])}, true
+
+	case strings.HasPrefix(firstLine, "RFB "):
+		return serviceInfo{service: "vnc", banner: banner, version: strings.TrimSpace(firstLine[4:])}, true
+
+	case len(raw) > 5 && raw[4] == 0x0a && bytes.IndexByte(raw[5:], 0x00) > 0:
+		// The MySQL handshake packet carries a null terminated server version after the protocol version.
+		version := string(raw[5 : 5+bytes.IndexByte(raw[5:], 0x00)])
+		return serviceInfo{service: "mysql", banner: banner, version: version}, true
+	}
+
+	if len(banner) > 0 {
+		return serviceInfo{service: serviceName(port), banner: banner}, true
+	}
+	return serviceInfo{}, false
+}
+
+// This function sends a HEAD request and reads the server header from the response.
+func httpProbe(detector serviceDetector, hostname string, address string) (serviceInfo, bool) {
+	connect, err := detector.dial(address)
+	if err != nil {
+		return serviceInfo{}, false
+	}
+	defer connect.Close()
+	return headRequest(connect, hostname, "http", detector.timeout(PROBE_TIMEOUT))
+}
+
+// This function performs the HEAD request on an already established connection.
+func headRequest(connect net.Conn, hostname string, service string, wait time.Duration) (serviceInfo, bool) {
+	connect.SetDeadline(time.Now().Add(wait))
+	_, err := connect.Write([]byte("HEAD / HTTP/1.0\r\nHost: " + hostname + "\r\nUser-Agent: matrix\r\n\r\n"))
+	if err != nil {
+		return serviceInfo{}, false
+	}
+	response, err := http.ReadResponse(bufio.NewReader(connect), nil)
+	if err != nil {
+		return serviceInfo{}, false
+	}
+	response.Body.Close()
+	banner := response.Proto + " " + response.Status
+	return serviceInfo{service: service, banner: banner, version: response.Header.Get("Server")}, true
+}
+
+// This function attempts a TLS handshake and, when it succeeds, looks for a web server behind it.
+func tlsProbe(detector serviceDetector, hostname string, address string) (serviceInfo, bool) {
+	rawConnection, err := detector.dial(address)
+	if err != nil {
+		return serviceInfo{}, false
+	}
+	defer rawConnection.Close()
+	rawConnection.SetDeadline(time.Now().Add(detector.timeout(PROBE_TIMEOUT)))
+
+	tlsConnection := tls.Client(rawConnection, &tls.Config{ServerName: hostname, InsecureSkipVerify: true})
+	if err := tlsConnection.Handshake(); err != nil {
+		return serviceInfo{}, false
+	}
+	state := tlsConnection.ConnectionState()
+	tlsVersion := tlsVersionName(state.Version)
+	subject := ""
+	if len(state.PeerCertificates) > 0 {
+		subject = "CN=" + state.PeerCertificates[0].Subject.CommonName
+	}
+
+	if info, found := headRequest(tlsConnection, hostname, "https", detector.timeout(PROBE_TIMEOUT)); found {
+		info.banner = strings.TrimSpace(info.banner + " " + subject)
+		info.version = strings.TrimSpace(info.version + " (" + tlsVersion + ")")
+		return info, true
+	}
+	_, port, _ := net.SplitHostPort(address)
+	portNumber, _ := strconv.Atoi(port)
+	return serviceInfo{service: "ssl/" + serviceName(portNumber), banner: subject, version: tlsVersion}, true
+}
+
+// This function converts the negotiated TLS version into a readable name.
+func tlsVersionName(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLSv1.0"
+	case tls.VersionTLS11:
+		return "TLSv1.1"
+	case tls.VersionTLS12:
+		return "TLSv1.2"
+	case tls.VersionTLS13:
+		return "TLSv1.3"
+	}
+	return "TLS 0x" + strconv.FormatUint(uint64(version), 16)
+}
+
+// This function sends a redis PING and waits for the PONG.
+func redisProbe(detector serviceDetector, hostname string, address string) (serviceInfo, bool) {
+	connect, err := detector.dial(address)
+	if err != nil {
+		return serviceInfo{}, false
+	}
+	defer connect.Close()
+	connect.SetDeadline(time.Now().Add(detector.timeout(PROBE_TIMEOUT)))
+	_, err = connect.Write([]byte("PING\r\n"))
+	if err != nil {
+		return serviceInfo{}, false
+	}
+	reply, err := bufio.NewReader(connect).ReadString('\n')
+	if err != nil {
+		return serviceInfo{}, false
+	}
+	// A protected redis still answers, only with an authentication error.
+	if strings.HasPrefix(reply, "+PONG") || strings.HasPrefix(reply, "-NOAUTH") {
+		return serviceInfo{service: "redis", banner: cleanBanner([]byte(reply))}, true
+	}
+	return serviceInfo{}, false
+}
+
+// This function returns the commonly used name of a port or "unknown".
+func serviceName(port int) string {
+	if name, found := wellKnownServices[port]; found {
+		return name
+	}
+	return "unknown"
+}
+
+// This function runs the probes in order, port specific probes first and,
+// only when the user asked for them, the generic probes afterwards.
+func runProbes(detector serviceDetector, hostname string, port int) (serviceInfo, bool) {
+	address := net.JoinHostPort(hostname, strconv.Itoa(port))
+	var generic []serviceProbe
+	for _, probe := range serviceProbes {
+		if containsPort(probe.ports, port) {
+			if info, found := probe.run(detector, hostname, address); found {
+				return info, true
+			}
+			continue
+		}
+		generic = append(generic, probe)
+	}
+	if !detector.probeAll {
+		return serviceInfo{}, false
+	}
+	for _, probe := range generic {
+		if info, found := probe.run(detector, hostname, address); found {
+			return info, true
+		}
+	}
+	return serviceInfo{}, false
+}
+
+func containsPort(ports []int, port int) bool {
+	for _, candidate := range ports {
+		if candidate == port {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Main detection function.
+This function is called with a freshly opened connection and tries to find out what service is listening on it.
+*/
+func detectService(detector serviceDetector, connect net.Conn, hostname string, port int) serviceInfo {
+	if detector.disabled {
+		return serviceInfo{service: serviceName(port)}
+	}
+	if info, found := identifyBanner(readBanner(connect, detector.timeout(BANNER_TIMEOUT)), port); found {
+		return info
+	}
+	// Some servers only handle one client at a time, so let go of our connection before probing.
+	connect.Close()
+	if info, found := runProbes(detector, hostname, port); found {
+		return info
+	}
+	return serviceInfo{service: serviceName(port)}
+}
@@ -0,0 +1,96 @@
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdentifyBanner(t *testing.T) {
+	tests := []struct {
+		name  string
+		raw   string
+		port  int
+		found bool
+		want  serviceInfo
+	}{
+		{"ssh", "SSH-2.0-OpenSSH_8.9p1 Ubuntu-3ubuntu0.1\r\n", 22, true,
+			serviceInfo{service: "ssh", banner: "SSH-2.0-OpenSSH_8.9p1 Ubuntu-3ubuntu0.1", version: "OpenSSH_8.9p1 Ubuntu-3ubuntu0.1"}},
+		{"ftp", "220 (vsFTPd 3.0.5)\r\n", 21, true,
+			serviceInfo{service: "ftp", banner: "220 (vsFTPd 3.0.5)", version: "(vsFTPd 3.0.5)"}},
+		{"smtp by text", "220 mail.example.com ESMTP Postfix (Ubuntu)\r\n", 2525, true,
+			serviceInfo{service: "smtp", banner: "220 mail.example.com ESMTP Postfix (Ubuntu)", version: "mail.example.com ESMTP Postfix (Ubuntu)"}},
+		{"smtp by port", "220-relay ready\r\n", 587, true,
+			serviceInfo{service: "smtp", banner: "220-relay ready", version: "relay ready"}},
+		{"bare 220", "220", 21, true, serviceInfo{service: "ftp", banner: "220", version: ""}},
+		{"pop3", "+OK Dovecot (Ubuntu) ready.\r\n", 110, true,
+			serviceInfo{service: "pop3", banner: "+OK Dovecot (Ubuntu) ready.", version: "Dovecot (Ubuntu) ready."}},
+		{"imap", "* OK [CAPABILITY IMAP4rev1 LITERAL+] Dovecot ready.\r\n", 143, true,
+			serviceInfo{service: "imap", banner: "* OK [CAPABILITY IMAP4rev1 LITERAL+] Dovecot ready.", version: "[CAPABILITY IMAP4rev1 LITERAL+] Dovecot ready."}},
+		{"vnc", "RFB 003.008\n", 5900, true, serviceInfo{service: "vnc", banner: "RFB 003.008", version: "003.008"}},
+		{"mysql", "J\x00\x00\x00\x0a8.0.32-0ubuntu0.22.04.2\x00\x08\x00\x00\x00abc", 3306, true,
+			serviceInfo{service: "mysql", banner: "J....8.0.32-0ubuntu0.22.04.2.....abc", version: "8.0.32-0ubuntu0.22.04.2"}},
+		// A handshake cut off before the end of the version is no MySQL handshake we can read.
+		{"truncated mysql", "J\x00\x00\x00\x0a8.0", 3306, true, serviceInfo{service: "mysql", banner: "J....8.0"}},
+		{"mysql header only", "J\x00\x00\x00\x0a", 3306, true, serviceInfo{service: "mysql", banner: "J..."}},
+		{"garbage", "\x00\xff\x01\x80\x7f", 9999, true, serviceInfo{service: serviceName(9999), banner: "....."}},
+		{"empty", "", 22, false, serviceInfo{}},
+		{"newlines only", "\r\n", 22, false, serviceInfo{}},
+	}
+	for _, test := range tests {
+		got, found := identifyBanner([]byte(test.raw), test.port)
+		if found != test.found || got != test.want {
+			t.Errorf("%s: got %+v, %v, want %+v, %v", test.name, got, found, test.want, test.found)
+		}
+	}
+}
+
+func TestHeadRequestReadsServerHeader(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		request := bufio.NewReader(server)
+		for {
+			line, err := request.ReadString('\n')
+			if err != nil || line == "\r\n" {
+				break
+			}
+		}
+		server.Write([]byte("HTTP/1.1 200 OK\r\nServer: nginx/1.18.0 (Ubuntu)\r\nContent-Length: 0\r\n\r\n"))
+	}()
+	got, found := headRequest(client, "example.com", "http", 5*time.Second)
+	want := serviceInfo{service: "http", banner: "HTTP/1.1 200 OK", version: "nginx/1.18.0 (Ubuntu)"}
+	if !found || got != want {
+		t.Errorf("got %+v, %v, want %+v", got, found, want)
+	}
+
+	// Something that is no HTTP at all is not taken for a web server.
+	client, server = net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		bufio.NewReader(server).ReadString('\n')
+		server.Write([]byte(strings.Repeat("\x00", 16)))
+	}()
+	if got, found := headRequest(client, "example.com", "http", 5*time.Second); found {
+		t.Errorf("got %+v from garbage, want nothing", got)
+	}
+}
@@ -0,0 +1,240 @@
+//go:build linux
+
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// A SYN gets lost as easily as any other packet, so it is sent a few times before the port is called filtered.
+const SYN_RETRIES = 2
+
+// The TCP flags we care about.
+const (
+	TCP_SYN = 0x02
+	TCP_RST = 0x04
+	TCP_ACK = 0x10
+)
+
+/*
+The SYN scanner sends bare SYN packets over a raw socket and waits for the SYN/ACK or RST.
+The handshake is never completed, our kernel knows nothing about the connection and resets it for us.
+One scanner probes any number of hosts, the replies are told apart by the host and port they come from.
+*/
+type synScanner struct {
+	conn       *net.IPConn
+	sourcePort uint16
+	lock       sync.Mutex
+	waiting    map[synProbe]chan byte
+	// The local address reaching every target, the checksum covers it.
+	sources map[string]net.IP
+	// math/rand is not seeded for us, every scanner keeps its own seeded source for the sequence numbers.
+	random *rand.Rand
+}
+
+// The host and port a probe waits for.
+type synProbe struct {
+	target string
+	port   uint16
+}
+
+/*
+Helping Functions
+*/
+// This function checks that we are allowed to open raw sockets.
+func SynScanAvailable() error {
+	conn, err := net.ListenIP("ip4:tcp", nil)
+	if err != nil {
+		return fmt.Errorf("the SYN scan needs superuser access or the CAP_NET_RAW capability: %w", err)
+	}
+	return conn.Close()
+}
+
+// This function finds the local address the kernel would use to reach the target.
+// Connecting a UDP socket sends nothing, it only asks the routing table.
+func localAddressFor(target net.IP) (net.IP, error) {
+	connect, err := net.Dial("udp4", net.JoinHostPort(target.String(), "9"))
+	if err != nil {
+		return nil, err
+	}
+	defer connect.Close()
+	return connect.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+// This function calculates the TCP checksum, which also covers a pseudo header made from the IP addresses.
+func tcpChecksum(source net.IP, target net.IP, segment []byte) uint16 {
+	pseudoHeader := make([]byte, 12)
+	copy(pseudoHeader[0:4], source.To4())
+	copy(pseudoHeader[4:8], target.To4())
+	pseudoHeader[9] = 6 // TCP
+	binary.BigEndian.PutUint16(pseudoHeader[10:12], uint16(len(segment)))
+
+	var sum uint32
+	for _, data := range [][]byte{pseudoHeader, segment} {
+		for i := 0; i+1 < len(data); i += 2 {
+			sum += uint32(binary.BigEndian.Uint16(data[i : i+2]))
+		}
+		if len(data)%2 == 1 {
+			sum += uint32(data[len(data)-1]) << 8
+		}
+	}
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return ^uint16(sum)
+}
+
+// This function opens the raw socket for scanning and starts listening for the replies.
+func newSynScanner() (*synScanner, error) {
+	conn, err := net.ListenIP("ip4:tcp", nil)
+	if err != nil {
+		return nil, fmt.Errorf("the SYN scan needs superuser access or the CAP_NET_RAW capability: %w", err)
+	}
+
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	scanner := &synScanner{
+		conn:       conn,
+		sourcePort: uint16(32768 + random.Intn(28232)),
+		waiting:    map[synProbe]chan byte{},
+		sources:    map[string]net.IP{},
+		random:     random,
+	}
+	go scanner.receive()
+	return scanner, nil
+}
+
+// This function finds the IPv4 address of the host to scan.
+func synTarget(hostname string) (net.IP, error) {
+	target, err := net.ResolveIPAddr("ip4", hostname)
+	if err != nil {
+		return nil, err
+	}
+	return target.IP, nil
+}
+
+// This function returns the local address reaching the target, asking the routing table once per target.
+func (scanner *synScanner) sourceFor(target net.IP) (net.IP, error) {
+	scanner.lock.Lock()
+	source, found := scanner.sources[target.String()]
+	scanner.lock.Unlock()
+	if found {
+		return source, nil
+	}
+	source, err := localAddressFor(target)
+	if err != nil {
+		return nil, err
+	}
+	scanner.lock.Lock()
+	scanner.sources[target.String()] = source
+	scanner.lock.Unlock()
+	return source, nil
+}
+
+// This function reads every TCP segment reaching our address and hands the replies of the target to the waiting probes.
+func (scanner *synScanner) receive() {
+	buffer := make([]byte, 1500)
+	for {
+		n, address, err := scanner.conn.ReadFrom(buffer)
+		if err != nil {
+			// The socket was closed, the scan is over.
+			return
+		}
+		if n < 20 || binary.BigEndian.Uint16(buffer[2:4]) != scanner.sourcePort {
+			continue
+		}
+		probe := synProbe{target: address.(*net.IPAddr).IP.String(), port: binary.BigEndian.Uint16(buffer[0:2])}
+		scanner.lock.Lock()
+		if reply, found := scanner.waiting[probe]; found {
+			select {
+			case reply <- buffer[13]:
+			default:
+			}
+		}
+		scanner.lock.Unlock()
+	}
+}
+
+// This function builds a TCP segment with only the SYN flag set.
+func (scanner *synScanner) synSegment(source net.IP, target net.IP, port uint16) []byte {
+	segment := make([]byte, 20)
+	binary.BigEndian.PutUint16(segment[0:2], scanner.sourcePort)
+	binary.BigEndian.PutUint16(segment[2:4], port)
+	scanner.lock.Lock()
+	binary.BigEndian.PutUint32(segment[4:8], scanner.random.Uint32())
+	scanner.lock.Unlock()
+	segment[12] = 5 << 4 // Header length of five words and no options.
+	segment[13] = TCP_SYN
+	binary.BigEndian.PutUint16(segment[14:16], 1024)
+	binary.BigEndian.PutUint16(segment[16:18], tcpChecksum(source, target, segment))
+	return segment
+}
+
+// This function sends a SYN to the port of the target and waits for the answer.
+func (scanner *synScanner) probe(ctx context.Context, target net.IP, port int, tracker *rttTracker) (string, string) {
+	if target.To4() == nil {
+		return "Error", "the SYN scan only supports IPv4"
+	}
+	source, err := scanner.sourceFor(target)
+	if err != nil {
+		return classifyDialError(err)
+	}
+	key := synProbe{target: target.String(), port: uint16(port)}
+	reply := make(chan byte, 1)
+	scanner.lock.Lock()
+	scanner.waiting[key] = reply
+	scanner.lock.Unlock()
+	defer func() {
+		scanner.lock.Lock()
+		delete(scanner.waiting, key)
+		scanner.lock.Unlock()
+	}()
+
+	for attempt := 0; attempt < SYN_RETRIES; attempt++ {
+		probeStart := time.Now()
+		_, err := scanner.conn.WriteTo(scanner.synSegment(source, target, uint16(port)), &net.IPAddr{IP: target})
+		if err != nil {
+			return classifyDialError(err)
+		}
+		select {
+		case flags := <-reply:
+			tracker.observe(time.Since(probeStart))
+			if flags&TCP_RST != 0 {
+				return "Closed", "reset"
+			}
+			if flags&(TCP_SYN|TCP_ACK) == TCP_SYN|TCP_ACK {
+				return "Open", "syn-ack"
+			}
+			return "Filtered", fmt.Sprintf("flags-0x%02x", flags)
+		case <-time.After(tracker.timeout()):
+		case <-ctx.Done():
+			return "Error", ctx.Err().Error()
+		}
+	}
+	return "Filtered", "no-response"
+}
+
+func (scanner *synScanner) close() {
+	scanner.conn.Close()
+}
@@ -0,0 +1,75 @@
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// This function returns a loopback port with nothing listening on it.
+func closedLoopbackPort(t *testing.T) int {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+	return port
+}
+
+func TestSynScanLoopback(t *testing.T) {
+	if err := SynScanAvailable(); err != nil {
+		t.Skip(err)
+	}
+
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	openPort := listener.Addr().(*net.TCPAddr).Port
+	closedPort := closedLoopbackPort(t)
+
+	scanner, err := newSynScanner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer scanner.close()
+	tracker := newRttTracker(MIN_TIMEOUT, 2*time.Second, false)
+	loopback := net.ParseIP("127.0.0.1")
+
+	if state, reason := scanner.probe(context.Background(), loopback, openPort, tracker); state != "Open" {
+		t.Errorf("port %d with a listener is %s (%s), want Open", openPort, state, reason)
+	}
+	if state, reason := scanner.probe(context.Background(), loopback, closedPort, tracker); state != "Closed" {
+		t.Errorf("port %d without a listener is %s (%s), want Closed", closedPort, state, reason)
+	}
+}
+
+func TestSynDiscoveryNeedsRawSockets(t *testing.T) {
+	if SynScanAvailable() == nil {
+		t.Skip("raw sockets are allowed here")
+	}
+	// Without raw sockets the scan has to fail, not report every host as down.
+	answers := make(chan pingResult, 1)
+	err := portPingSender(context.Background(), "syn", []net.IP{net.ParseIP("127.0.0.1")}, []int{80}, answers, time.Second)
+	if err == nil {
+		t.Error("a SYN discovery without raw sockets should fail")
+	}
+}
@@ -0,0 +1,48 @@
+//go:build !linux
+
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+var errSynUnsupported = errors.New("the SYN scan is only supported on Linux")
+
+// Raw TCP sockets behave differently on every platform, so the SYN scan is only built for Linux.
+type synScanner struct{}
+
+func SynScanAvailable() error {
+	return errSynUnsupported
+}
+
+func newSynScanner() (*synScanner, error) {
+	return nil, errSynUnsupported
+}
+
+func synTarget(hostname string) (net.IP, error) {
+	return nil, errSynUnsupported
+}
+
+func (scanner *synScanner) probe(ctx context.Context, target net.IP, port int, tracker *rttTracker) (string, string) {
+	return "Error", errSynUnsupported.Error()
+}
+
+func (scanner *synScanner) close() {}
@@ -0,0 +1,151 @@
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The most hosts a single run is allowed to target, a /16 network.
+const TARGET_LIMIT = 65536
+
+/*
+Helping Functions
+*/
+// This function expands a dash range into its addresses.
+// Both the short form 10.0.0.1-50 and the long form 10.0.0.1-10.0.0.50 are understood.
+func expandRange(target string) ([]string, error) {
+	parts := strings.SplitN(target, "-", 2)
+	first := net.ParseIP(strings.TrimSpace(parts[0])).To4()
+	if first == nil {
+		return nil, fmt.Errorf("invalid range %q: only IPv4 ranges are supported", target)
+	}
+
+	end := strings.TrimSpace(parts[1])
+	last := net.ParseIP(end).To4()
+	if last == nil {
+		octet, err := strconv.Atoi(end)
+		if err != nil || octet < 0 || octet > 255 {
+			return nil, fmt.Errorf("invalid range %q: %q is neither an address nor an octet", target, end)
+		}
+		last = net.IPv4(first[0], first[1], first[2], byte(octet)).To4()
+	}
+
+	start := binary.BigEndian.Uint32(first)
+	finish := binary.BigEndian.Uint32(last)
+	if finish < start {
+		return nil, fmt.Errorf("invalid range %q: the range ends before it starts", target)
+	}
+	if finish-start >= TARGET_LIMIT {
+		return nil, fmt.Errorf("invalid range %q: more than %d addresses", target, TARGET_LIMIT)
+	}
+
+	// Count instead of comparing addresses, a range ending at 255.255.255.255 would wrap around forever.
+	var addresses []string
+	for offset := uint64(0); offset <= uint64(finish-start); offset++ {
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, start+uint32(offset))
+		addresses = append(addresses, ip.String())
+	}
+	return addresses, nil
+}
+
+// This function expands a single target into the hosts it stands for.
+func expandTarget(target string) ([]string, error) {
+	switch {
+	case strings.Contains(target, "/"):
+		ips, err := expandCIDR(target)
+		if err != nil {
+			return nil, err
+		}
+		var addresses []string
+		for _, ip := range ips {
+			addresses = append(addresses, ip.String())
+		}
+		return addresses, nil
+	case strings.Contains(target, "-") && net.ParseIP(strings.SplitN(target, "-", 2)[0]) != nil:
+		return expandRange(target)
+	}
+	// Anything else is taken as a single address or hostname.
+	return []string{target}, nil
+}
+
+// This function reads the targets from a file, one or more per line.
+// Empty lines and everything after a # are ignored.
+func readTargetsFile(targetsFile string) ([]string, error) {
+	file, err := os.Open(targetsFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var targets []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.SplitN(scanner.Text(), "#", 2)[0]
+		targets = append(targets, strings.FieldsFunc(line, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		})...)
+	}
+	return targets, scanner.Err()
+}
+
+/*
+Main parsing function.
+This function converts the targets given by the user into a list of unique hosts, in the order they were given.
+*/
+func ParseTargets(targetSpec string, targetsFile string) ([]string, error) {
+	targets := strings.Split(targetSpec, ",")
+	if targetsFile != "" {
+		fileTargets, err := readTargetsFile(targetsFile)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, fileTargets...)
+	}
+
+	var hosts []string
+	seen := map[string]bool{}
+	for _, target := range targets {
+		target = strings.TrimSpace(target)
+		if target == "" {
+			continue
+		}
+		expanded, err := expandTarget(target)
+		if err != nil {
+			return nil, err
+		}
+		for _, host := range expanded {
+			if !seen[host] {
+				seen[host] = true
+				hosts = append(hosts, host)
+			}
+		}
+		if len(hosts) > TARGET_LIMIT {
+			return nil, fmt.Errorf("too many targets: matrix scans at most %d hosts at once", TARGET_LIMIT)
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no targets to scan")
+	}
+	return hosts, nil
+}
@@ -0,0 +1,94 @@
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseTargets(t *testing.T) {
+	tests := []struct {
+		name      string
+		spec      string
+		wantCount int
+		wantFirst string
+		wantLast  string
+		wantError bool
+	}{
+		{name: "single host", spec: "example.com", wantCount: 1, wantFirst: "example.com", wantLast: "example.com"},
+		{name: "dashed hostname", spec: "my-host.lan", wantCount: 1, wantFirst: "my-host.lan", wantLast: "my-host.lan"},
+		{name: "list with duplicates", spec: "10.0.0.1, 10.0.0.2,10.0.0.1,,", wantCount: 2, wantFirst: "10.0.0.1", wantLast: "10.0.0.2"},
+		{name: "cidr", spec: "192.168.1.0/30", wantCount: 4, wantFirst: "192.168.1.0", wantLast: "192.168.1.3"},
+		{name: "cidr at the top of the address space", spec: "255.255.255.0/24", wantCount: 256, wantFirst: "255.255.255.0", wantLast: "255.255.255.255"},
+		{name: "octet range", spec: "10.0.0.1-50", wantCount: 50, wantFirst: "10.0.0.1", wantLast: "10.0.0.50"},
+		{name: "octet range at the top of the address space", spec: "255.255.255.250-255", wantCount: 6, wantFirst: "255.255.255.250", wantLast: "255.255.255.255"},
+		{name: "address range across octets", spec: "10.0.0.254-10.0.1.1", wantCount: 4, wantFirst: "10.0.0.254", wantLast: "10.0.1.1"},
+		{name: "single address range", spec: "10.0.0.7-7", wantCount: 1, wantFirst: "10.0.0.7", wantLast: "10.0.0.7"},
+		{name: "reversed range", spec: "10.0.0.50-1", wantError: true},
+		{name: "octet out of bounds", spec: "10.0.0.1-300", wantError: true},
+		{name: "garbage range end", spec: "10.0.0.1-abc", wantError: true},
+		{name: "range too large", spec: "10.0.0.0-10.1.0.0", wantError: true},
+		{name: "ipv6 cidr", spec: "2001:db8::/126", wantCount: 4, wantFirst: "2001:db8::", wantLast: "2001:db8::3"},
+		{name: "ipv6 cidr at the top of the address space", spec: "ffff:ffff:ffff:ffff:ffff:ffff:ffff:fffe/127", wantCount: 2, wantFirst: "ffff:ffff:ffff:ffff:ffff:ffff:ffff:fffe", wantLast: "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"},
+		{name: "ipv6 cidr too large", spec: "fe80::/64", wantError: true},
+		{name: "cidr too large", spec: "10.0.0.0/8", wantError: true},
+		{name: "bad cidr", spec: "10.0.0.0/33", wantError: true},
+		{name: "nothing to scan", spec: " , ", wantError: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hosts, err := ParseTargets(test.spec, "")
+			if test.wantError {
+				if err == nil {
+					t.Fatalf("ParseTargets(%q) = %d hosts, want an error", test.spec, len(hosts))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseTargets(%q) failed: %v", test.spec, err)
+			}
+			if len(hosts) != test.wantCount || hosts[0] != test.wantFirst || hosts[len(hosts)-1] != test.wantLast {
+				t.Errorf("ParseTargets(%q) = %d hosts from %s to %s, want %d hosts from %s to %s",
+					test.spec, len(hosts), hosts[0], hosts[len(hosts)-1], test.wantCount, test.wantFirst, test.wantLast)
+			}
+		})
+	}
+}
+
+func TestParseTargetsFile(t *testing.T) {
+	targetsFile := filepath.Join(t.TempDir(), "targets.txt")
+	content := "# lab hosts\n10.0.0.1 10.0.0.2 # the routers\n\n\tdb.lan,10.0.0.1\n10.0.0.8/31\n"
+	if err := os.WriteFile(targetsFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	hosts, err := ParseTargets("web.lan", targetsFile)
+	if err != nil {
+		t.Fatalf("ParseTargets failed: %v", err)
+	}
+	want := []string{"web.lan", "10.0.0.1", "10.0.0.2", "db.lan", "10.0.0.8", "10.0.0.9"}
+	if !reflect.DeepEqual(hosts, want) {
+		t.Errorf("ParseTargets = %v, want %v", hosts, want)
+	}
+
+	if _, err := ParseTargets("", filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("ParseTargets with a missing file did not fail")
+	}
+}
@@ -0,0 +1,192 @@
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// How long a generated self-signed certificate stays valid.
+const SELF_SIGNED_VALIDITY = 7 * 24 * time.Hour
+
+/*
+TLS settings of the test servers and clients.
+A server presents CertFile and KeyFile, or a self-signed certificate generated at start when there are none,
+and with a CAFile it asks every client for a certificate signed by that CA (mutual TLS).
+A client trusts the CAs of CAFile besides the ones of the system, or nothing at all with Insecure,
+and presents CertFile and KeyFile to servers asking for a client certificate.
+*/
+type TLSOptions struct {
+	CertFile string
+	KeyFile  string
+	CAFile   string
+	Insecure bool
+}
+
+// The names of the TLS versions, the standard library only names them from Go 1.21 on.
+var tlsVersionNames = map[uint16]string{
+	tls.VersionTLS10: "TLS 1.0",
+	tls.VersionTLS11: "TLS 1.1",
+	tls.VersionTLS12: "TLS 1.2",
+	tls.VersionTLS13: "TLS 1.3",
+}
+
+// This function builds the TLS configuration of a test server.
+func ServerTLSConfig(options TLSOptions) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if options.CertFile == "" && options.KeyFile == "" {
+		certificate, err := selfSignedCertificate(selfSignedHosts())
+		if err != nil {
+			return nil, fmt.Errorf("cannot generate a self-signed certificate: %w", err)
+		}
+		fingerprint := sha256.Sum256(certificate.Certificate[0])
+		fmt.Printf("Generated a self-signed certificate for %s.\nSHA-256 fingerprint: %X\n", strings.Join(selfSignedHosts(), ", "), fingerprint)
+		config.Certificates = []tls.Certificate{certificate}
+	} else {
+		certificate, err := loadKeyPair(options)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	if options.CAFile != "" {
+		pool, err := loadCertificatePool(options.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// This function builds the TLS configuration of a test client talking to the host.
+func ClientTLSConfig(options TLSOptions, host string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: host, InsecureSkipVerify: options.Insecure}
+	if options.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		content, err := os.ReadFile(options.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(content) {
+			return nil, fmt.Errorf("no PEM certificate found in %s", options.CAFile)
+		}
+		config.RootCAs = pool
+	}
+	if options.CertFile != "" || options.KeyFile != "" {
+		certificate, err := loadKeyPair(options)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return config, nil
+}
+
+// This function describes what a TLS handshake settled on, like "TLS 1.3, TLS_AES_128_GCM_SHA256".
+// The subject of the peer certificate follows when the peer sent one.
+func DescribeTLS(state tls.ConnectionState) string {
+	version, ok := tlsVersionNames[state.Version]
+	if !ok {
+		version = fmt.Sprintf("TLS 0x%04x", state.Version)
+	}
+	description := version + ", " + tls.CipherSuiteName(state.CipherSuite)
+	if len(state.PeerCertificates) > 0 {
+		description += ", peer " + state.PeerCertificates[0].Subject.String()
+	}
+	return description
+}
+
+/* Helping Functions */
+
+// This function loads the certificate and key, both of them have to be given.
+func loadKeyPair(options TLSOptions) (tls.Certificate, error) {
+	if options.CertFile == "" || options.KeyFile == "" {
+		return tls.Certificate{}, errors.New("a certificate needs both --cert and --key")
+	}
+	return tls.LoadX509KeyPair(options.CertFile, options.KeyFile)
+}
+
+// This function reads the PEM certificates of a CA file into a pool.
+func loadCertificatePool(path string) (*x509.CertPool, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(content) {
+		return nil, fmt.Errorf("no PEM certificate found in %s", path)
+	}
+	return pool, nil
+}
+
+// This function lists the names a self-signed certificate is made for: the loopback and the name of this machine.
+func selfSignedHosts() []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" && hostname != "localhost" {
+		hosts = append(hosts, hostname)
+	}
+	return hosts
+}
+
+// This function generates a certificate for the hosts, signed by its own ECDSA key.
+func selfSignedCertificate(hosts []string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{"matrix test server"}, CommonName: hosts[0]},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(SELF_SIGNED_VALIDITY),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{certificate}, PrivateKey: key}, nil
+}
@@ -0,0 +1,181 @@
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The PEM files of a test CA, of a server certificate for the loopback and of a client certificate, both signed by the CA.
+type testCertificates struct {
+	ca, serverCert, serverKey, clientCert, clientKey string
+}
+
+// This function issues a certificate signed by the parent, or by itself without one, and writes it and its key as PEM files.
+func issueTestCertificate(t *testing.T, directory, name string, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore, template.NotAfter = time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(directory, name+".pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644)
+	os.WriteFile(filepath.Join(directory, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600)
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certificate, key
+}
+
+// This function writes a test CA with a server and a client certificate to a test directory.
+func writeTestCertificates(t *testing.T) testCertificates {
+	t.Helper()
+	directory := t.TempDir()
+	ca, caKey := issueTestCertificate(t, directory, "ca", &x509.Certificate{
+		Subject: pkix.Name{CommonName: "test CA"}, IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign,
+	}, nil, nil)
+	issueTestCertificate(t, directory, "server", &x509.Certificate{
+		Subject: pkix.Name{CommonName: "server"}, IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+	issueTestCertificate(t, directory, "client", &x509.Certificate{
+		Subject: pkix.Name{CommonName: "client"}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+	path := func(name string) string { return filepath.Join(directory, name) }
+	return testCertificates{path("ca.pem"), path("server.pem"), path("server.key"), path("client.pem"), path("client.key")}
+}
+
+// This function splits the address of a test server into the client configuration reaching it.
+func testClientConfig(t *testing.T, address string, options TLSOptions) ClientConfig {
+	t.Helper()
+	host, port, _ := net.SplitHostPort(address)
+	number, _ := strconv.Atoi(port)
+	config, err := ClientTLSConfig(options, host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ClientConfig{Host: host, Port: number, Path: "/", TLS: config}
+}
+
+func TestTcpClientWithSelfSignedServer(t *testing.T) {
+	serverTLS, err := ServerTLSConfig(TLSOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := startTestServer(t, ServerConfig{Reply: "ECHO", Framing: "newline", TLS: serverTLS})
+
+	// The self-signed certificate is refused unless the client accepts any certificate.
+	if err := tcpClient(testClientConfig(t, address, TLSOptions{}), strings.NewReader("hello\n"), &bytes.Buffer{}); err == nil {
+		t.Error("the self-signed certificate should not be trusted")
+	}
+	var output bytes.Buffer
+	if err := tcpClient(testClientConfig(t, address, TLSOptions{Insecure: true}), strings.NewReader("hello\nlast"), &output); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Connected with TLS 1.3", "-> Echo: hello\n", "-> Echo: last\n"} {
+		if !strings.Contains(output.String(), want) {
+			t.Errorf("got %q, want it to contain %q", output.String(), want)
+		}
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	files := writeTestCertificates(t)
+	serverTLS, err := ServerTLSConfig(TLSOptions{CertFile: files.serverCert, KeyFile: files.serverKey, CAFile: files.ca})
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := startTestServer(t, ServerConfig{Reply: "ECHO", Framing: "newline", TLS: serverTLS})
+
+	var output bytes.Buffer
+	withCertificate := TLSOptions{CAFile: files.ca, CertFile: files.clientCert, KeyFile: files.clientKey}
+	if err := tcpClient(testClientConfig(t, address, withCertificate), strings.NewReader("hello\n"), &output); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output.String(), "peer CN=server") || !strings.Contains(output.String(), "-> Echo: hello\n") {
+		t.Errorf("got %q, want the verified server to answer", output.String())
+	}
+	// Without a certificate the server hangs up, the client notices at the latest when it waits for the reply.
+	output.Reset()
+	err = tcpClient(testClientConfig(t, address, TLSOptions{CAFile: files.ca}), strings.NewReader("hello\n"), &output)
+	refused := err != nil || strings.Contains(output.String(), "Server Closed Connection.")
+	if !refused || strings.Contains(output.String(), "Echo") {
+		t.Errorf("got %q, %v, want the client without a certificate refused", output.String(), err)
+	}
+}
+
+func TestWebsocketClientOverTLS(t *testing.T) {
+	serverTLS, err := ServerTLSConfig(TLSOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(websocketHandler(ServerConfig{Reply: "ECHO"}))
+	server.TLS = serverTLS
+	server.StartTLS()
+	defer server.Close()
+
+	var output bytes.Buffer
+	config := testClientConfig(t, strings.TrimPrefix(server.URL, "https://"), TLSOptions{Insecure: true})
+	if err := websocketClient(config, strings.NewReader("hello\n"), &output); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Connected with TLS 1.3", "<-  Echo: hello\n"} {
+		if !strings.Contains(output.String(), want) {
+			t.Errorf("got %q, want it to contain %q", output.String(), want)
+		}
+	}
+}
+
+func TestTLSOptionsErrors(t *testing.T) {
+	if _, err := ServerTLSConfig(TLSOptions{CertFile: "server.pem"}); err == nil {
+		t.Error("a certificate without its key should be refused")
+	}
+	if _, err := ClientTLSConfig(TLSOptions{CAFile: filepath.Join(t.TempDir(), "missing.pem")}, "localhost"); err == nil {
+		t.Error("a missing CA file should be refused")
+	}
+	if description := DescribeTLS(tls.ConnectionState{Version: 0x0305, CipherSuite: tls.TLS_AES_128_GCM_SHA256}); description != "TLS 0x0305, TLS_AES_128_GCM_SHA256" {
+		t.Errorf("got %q", description)
+	}
+}
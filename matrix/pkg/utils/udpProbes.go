@@ -0,0 +1,67 @@
+/*
+Copyright © [2022] [Lakshy Sharma] <lakshy.sharma@protonmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+// UDP services rarely answer an empty datagram, so for the well known ports we send
+// a small request that the service is expected to reply to.
+type udpProbe struct {
+	service string
+	payload []byte
+}
+
+// A DNS query asking for the NS records of the root zone.
+var dnsProbe = []byte{
+	0x13, 0x37, // Transaction ID
+	0x01, 0x00, // Standard query with recursion desired.
+	0x00, 0x01, // One question.
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // No answer, authority or additional records.
+	0x00,       // The root name.
+	0x00, 0x02, // Type NS
+	0x00, 0x01, // Class IN
+}
+
+// A NTP version 3 client request, the rest of the 48 byte packet is left empty.
+var ntpProbe = append([]byte{0x1b}, make([]byte, 47)...)
+
+// A SNMPv1 GetRequest for sysDescr.0 using the "public" community.
+var snmpProbe = []byte{
+	0x30, 0x29, // Sequence
+	0x02, 0x01, 0x00, // Version 1
+	0x04, 0x06, 'p', 'u', 'b', 'l', 'i', 'c', // Community
+	0xa0, 0x1c, // GetRequest PDU
+	0x02, 0x04, 0x00, 0x00, 0x00, 0x01, // Request ID
+	0x02, 0x01, 0x00, // Error status
+	0x02, 0x01, 0x00, // Error index
+	0x30, 0x0e, 0x30, 0x0c, // Varbind list
+	0x06, 0x08, 0x2b, 0x06, 0x01, 0x02, 0x01, 0x01, 0x01, 0x00, // 1.3.6.1.2.1.1.1.0
+	0x05, 0x00, // NULL
+}
+
+var udpProbes = map[int]udpProbe{
+	53:   {service: "dns", payload: dnsProbe},
+	123:  {service: "ntp", payload: ntpProbe},
+	161:  {service: "snmp", payload: snmpProbe},
+	5353: {service: "mdns", payload: dnsProbe},
+}
+
+// This function returns the probe to send to a UDP port.
+// Ports we know nothing about receive an empty datagram and are named after the well known service table.
+func udpProbeFor(port int) udpProbe {
+	if probe, found := udpProbes[port]; found {
+		return probe
+	}
+	return udpProbe{service: serviceName(port), payload: []byte{}}
+}